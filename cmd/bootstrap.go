@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	vault "github.com/kubefirst/vault-handler/internal/vault"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vaultKubernetesAuthOpts *vault.VaultKubernetesAuthExecutionOptions = &vault.VaultKubernetesAuthExecutionOptions{}
+)
+
+// bootstrapCmd represents the bootstrap command
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Configure an initialized vault cluster",
+	Long:  `Configure an initialized vault cluster`,
+}
+
+// bootstrapKubernetesAuthCmd represents the bootstrap kubernetes-auth command
+var bootstrapKubernetesAuthCmd = &cobra.Command{
+	Use:   "kubernetes-auth",
+	Short: "Enable and configure the kubernetes auth method",
+	Long: `Enable the kubernetes auth method on the raft leader and point it at the API
+server the handler runs in, trusting the CA of the handler's own Pod - the
+roles listed in --roles-file are then created
+
+Vault reviews tokens with its own service account token unless a reviewer
+token is supplied with --token-reviewer-jwt-file. --handler-token-reviewer-jwt
+writes the token of the handler's Pod instead, which is short-lived and stops
+working once the Pod is gone
+
+Outside the cluster the local Kubernetes credentials are never written to
+vault, so --kubernetes-host is required
+
+The config and roles are only written when they differ from what vault holds,
+so reruns change nothing - a supplied reviewer token is always rewritten`,
+	Run: func(cmd *cobra.Command, args []string) {
+		vaultClient := &vault.Conf
+		_, clientset := createKubeConfig(vaultKubernetesAuthOpts.KubeInClusterConfig)
+		defer vault.Conf.Close()
+		err := vaultClient.BootstrapKubernetesAuth(clientset, vaultKubernetesAuthOpts)
+		if err != nil {
+			log.Fatalf("error bootstrapping the kubernetes auth method: %s", err)
+		}
+		log.Info("kubernetes auth method bootstrapped successfully!")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(bootstrapCmd)
+	bootstrapCmd.AddCommand(bootstrapKubernetesAuthCmd)
+
+	bootstrapKubernetesAuthCmd.Flags().StringVar(&vaultKubernetesAuthOpts.Mount, "auth-mount", vault.KubernetesAuthMount, "path the kubernetes auth method is enabled at")
+	bootstrapKubernetesAuthCmd.Flags().StringVar(&vaultKubernetesAuthOpts.KubernetesHost, "kubernetes-host", "", "API server address vault reviews tokens with - defaults to the in cluster address, required outside the cluster")
+	bootstrapKubernetesAuthCmd.Flags().StringVar(&vaultKubernetesAuthOpts.KubernetesCAFile, "kubernetes-ca-file", "", "path to the PEM encoded CA of the API server - defaults to the in cluster CA")
+	bootstrapKubernetesAuthCmd.Flags().StringVar(&vaultKubernetesAuthOpts.TokenReviewerJWTFile, "token-reviewer-jwt-file", "", "path to the service account token vault reviews tokens with - defaults to vault's own")
+	bootstrapKubernetesAuthCmd.Flags().BoolVar(&vaultKubernetesAuthOpts.HandlerTokenReviewerJWT, "handler-token-reviewer-jwt", false, "review tokens with the short-lived service account token of the handler's own Pod, in cluster only")
+	bootstrapKubernetesAuthCmd.Flags().StringVar(&vaultKubernetesAuthOpts.RolesFile, "roles-file", "", "path to a yaml or json list of roles, each with a name, service_account, namespace, policies and ttl")
+	bootstrapKubernetesAuthCmd.Flags().BoolVar(&vaultKubernetesAuthOpts.KubeInClusterConfig, "use-kubeconfig-in-cluster", true, "kube config type - in-cluster (default), set to false to use local")
+}
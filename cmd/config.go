@@ -0,0 +1,322 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// Prefix of the environment variables that set flags
+	envPrefix string = "VAULT_HANDLER_"
+	// Annotation marking flags whose values are redacted by config view
+	secretAnnotation string = "vault-handler/secret"
+	// Value printed in place of secrets
+	redactedValue string = "REDACTED"
+)
+
+var (
+	// cfgFile is the path of the config file set by --config
+	cfgFile string
+	// loadedConfig holds the settings read from the config file
+	loadedConfig map[string]interface{}
+	// loadedConfigFile is the path the settings were read from, if any
+	loadedConfigFile string
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the handler configuration",
+	Long:  `Inspect the handler configuration`,
+}
+
+// configViewCmd represents the config view command
+var configViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Print the effective configuration",
+	Long: `Print the effective configuration of every command as yaml, merged from
+flags, VAULT_HANDLER_* environment variables, the config file and defaults -
+secrets are redacted`,
+	Run: func(cmd *cobra.Command, args []string) {
+		view, err := effectiveConfig()
+		if err != nil {
+			log.Fatalf("error building the effective configuration: %s", err)
+		}
+		content, err := yaml.Marshal(view)
+		if err != nil {
+			log.Fatalf("error writing the effective configuration: %s", err)
+		}
+		if loadedConfigFile != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "# config file: %s\n", loadedConfigFile)
+		}
+		fmt.Fprint(cmd.OutOrStdout(), string(content))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configViewCmd)
+
+	// Set here rather than in rootCmd, which loadConfig refers to
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		// Usage doesn't help with configuration errors
+		cmd.SilenceUsage = true
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+		if err := configureLogging(cmd); err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+// loadConfig applies the config file and environment to every flag of a
+// command that wasn't set on the command line, so that flags take precedence
+// over the environment, which takes precedence over the file and then defaults
+//
+// Global flags are read from the top level of the config file and from
+// VAULT_HANDLER_<FLAG>, while the flags of a command are read from a section
+// named after it and from VAULT_HANDLER_<COMMAND>_<FLAG>, for example
+// unseal.leader-only and VAULT_HANDLER_UNSEAL_LEADER_ONLY
+func loadConfig(cmd *cobra.Command) error {
+	path, explicit := configPath()
+	if path != "" {
+		content, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			values := map[string]interface{}{}
+			if err := yaml.Unmarshal(content, &values); err != nil {
+				return fmt.Errorf("error parsing config file %s: %s", path, err)
+			}
+			if err := validateConfig(values, rootCmd, rootCmd.PersistentFlags(), ""); err != nil {
+				return fmt.Errorf("error in config file %s: %s", path, err)
+			}
+			loadedConfig = values
+			loadedConfigFile = path
+			log.Debugf("read config file %s", path)
+		case explicit || !os.IsNotExist(err):
+			return fmt.Errorf("error reading config file %s: %s", path, err)
+		}
+	}
+
+	if err := applyConfig(rootCmd, rootCmd.PersistentFlags()); err != nil {
+		return err
+	}
+	if cmd != rootCmd {
+		return applyConfig(cmd, cmd.LocalNonPersistentFlags())
+	}
+	return nil
+}
+
+// configPath returns the config file to read and whether it was chosen
+// explicitly, in which case it must exist
+func configPath() (string, bool) {
+	if cfgFile != "" {
+		return cfgFile, true
+	}
+	if path, ok := os.LookupEnv(envPrefix + "CONFIG"); ok && path != "" {
+		return path, true
+	}
+
+	var candidates []string
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".vault-handler.yaml"))
+	}
+	candidates = append(candidates, "/etc/vault-handler/config.yaml")
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, false
+		}
+	}
+	return "", false
+}
+
+// applyConfig sets every flag that wasn't set on the command line from its
+// environment variable or, failing that, the command's section of the config file
+func applyConfig(cmd *cobra.Command, flags *pflag.FlagSet) error {
+	section := configSection(cmd)
+
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "help" || flag.Name == "config" {
+			return
+		}
+
+		env := envName(cmd, flag.Name)
+		if value, ok := os.LookupEnv(env); ok {
+			if setErr := flags.Set(flag.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q in %s: %s", value, env, setErr)
+			}
+			return
+		}
+		if value, ok := section[flag.Name]; ok {
+			if setErr := flags.Set(flag.Name, configValue(value)); setErr != nil {
+				err = fmt.Errorf("invalid value %v for %s in config file %s: %s", value, settingName(cmd, flag.Name), loadedConfigFile, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// configSection returns the settings of the config file that apply to a
+// command, nested under its command path
+func configSection(cmd *cobra.Command) map[string]interface{} {
+	section := loadedConfig
+	for _, name := range commandPath(cmd) {
+		next, _ := section[name].(map[string]interface{})
+		section = next
+	}
+	return section
+}
+
+// validateConfig rejects config file settings that no command reads, so that
+// a typo isn't silently ignored
+func validateConfig(values map[string]interface{}, cmd *cobra.Command, flags *pflag.FlagSet, prefix string) error {
+	for key, value := range values {
+		if flag := flags.Lookup(key); flag != nil && key != "help" && key != "config" {
+			continue
+		}
+
+		var subcommand *cobra.Command
+		for _, candidate := range cmd.Commands() {
+			if candidate.Name() == key {
+				subcommand = candidate
+			}
+		}
+		if subcommand == nil {
+			return fmt.Errorf("unknown setting %s%s", prefix, key)
+		}
+		section, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s%s must hold the settings of the %s command", prefix, key, subcommand.CommandPath())
+		}
+		if err := validateConfig(section, subcommand, subcommand.LocalNonPersistentFlags(), prefix+key+"."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// effectiveConfig returns the merged settings of every command, in the layout
+// of the config file
+func effectiveConfig() (map[string]interface{}, error) {
+	view := flagValues(rootCmd.PersistentFlags())
+
+	var walk func(cmd *cobra.Command) error
+	walk = func(cmd *cobra.Command) error {
+		if cmd.Name() == "help" || cmd.Name() == "completion" {
+			return nil
+		}
+		for _, subcommand := range cmd.Commands() {
+			if err := walk(subcommand); err != nil {
+				return err
+			}
+		}
+		if cmd == rootCmd || !cmd.Runnable() {
+			return nil
+		}
+
+		flags := cmd.LocalNonPersistentFlags()
+		if err := applyConfig(cmd, flags); err != nil {
+			return err
+		}
+		values := flagValues(flags)
+		if len(values) == 0 {
+			return nil
+		}
+
+		section := view
+		path := commandPath(cmd)
+		for _, name := range path[:len(path)-1] {
+			next, ok := section[name].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				section[name] = next
+			}
+			section = next
+		}
+		section[path[len(path)-1]] = values
+		return nil
+	}
+
+	return view, walk(rootCmd)
+}
+
+// flagValues returns the typed value of every flag, with secrets redacted
+//
+// Several commands bind flags to the same setting, so flags that weren't set
+// report their default rather than the value another command set
+func flagValues(flags *pflag.FlagSet) map[string]interface{} {
+	values := map[string]interface{}{}
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "help" || flag.Name == "config" {
+			return
+		}
+		value := flag.DefValue
+		if flag.Changed {
+			value = flag.Value.String()
+		}
+		if _, secret := flag.Annotations[secretAnnotation]; secret {
+			if value != "" {
+				value = redactedValue
+			}
+			values[flag.Name] = value
+			return
+		}
+
+		switch flag.Value.Type() {
+		case "bool":
+			values[flag.Name], _ = strconv.ParseBool(value)
+		case "int":
+			values[flag.Name], _ = strconv.Atoi(value)
+		case "stringSlice":
+			values[flag.Name] = flag.Value.(pflag.SliceValue).GetSlice()
+		default:
+			values[flag.Name] = value
+		}
+	})
+	return values
+}
+
+// configValue returns a config file value in the form its flag parses
+func configValue(value interface{}) string {
+	switch value := value.(type) {
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, configValue(item))
+		}
+		return strings.Join(items, ",")
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// commandPath returns the names of a command below the root command
+func commandPath(cmd *cobra.Command) []string {
+	return strings.Fields(cmd.CommandPath())[1:]
+}
+
+// envName returns the environment variable setting a flag of a command
+func envName(cmd *cobra.Command, flag string) string {
+	parts := append(commandPath(cmd), flag)
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(strings.Join(parts, "_"), "-", "_"))
+}
+
+// settingName returns the config file setting of a flag of a command
+func settingName(cmd *cobra.Command, flag string) string {
+	return strings.Join(append(commandPath(cmd), flag), ".")
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// executeRoot runs the root command with args, returning its output, and
+// restores every flag and the loaded config once the test ends
+func executeRoot(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	t.Cleanup(func() {
+		cfgFile, loadedConfig, loadedConfigFile = "", nil, ""
+		var reset func(cmd *cobra.Command)
+		reset = func(cmd *cobra.Command) {
+			cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+				if slice, ok := flag.Value.(pflag.SliceValue); ok {
+					_ = slice.Replace(nil)
+				} else {
+					_ = flag.Value.Set(flag.DefValue)
+				}
+				flag.Changed = false
+			})
+			for _, subcommand := range cmd.Commands() {
+				reset(subcommand)
+			}
+		}
+		reset(rootCmd)
+	})
+
+	var output bytes.Buffer
+	rootCmd.SetOut(&output)
+	rootCmd.SetErr(io.Discard)
+	rootCmd.SetArgs(args)
+	t.Cleanup(func() {
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+		rootCmd.SetArgs(nil)
+	})
+	err := rootCmd.Execute()
+	return output.String(), err
+}
+
+// writeConfigFile writes a config file to a temporary directory
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path := writeConfigFile(t, `namespace: file-namespace
+statefulset: file-statefulset
+key-store-secret-name: file-secret
+status:
+  output: json
+seal:
+  output: json
+`)
+	t.Setenv("VAULT_HANDLER_NAMESPACE", "env-namespace")
+	t.Setenv("VAULT_HANDLER_STATEFULSET", "env-statefulset")
+	t.Setenv("VAULT_HANDLER_SEAL_OUTPUT", "yaml")
+
+	output, err := executeRoot(t, "config", "view", "--config", path, "--namespace", "flag-namespace", "--token", "hvs.secret")
+	if err != nil {
+		t.Fatalf("config view error = %v", err)
+	}
+	if !strings.HasPrefix(output, "# config file: "+path+"\n") {
+		t.Errorf("config view output doesn't name the config file:\n%s", output)
+	}
+	view := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(output), &view); err != nil {
+		t.Fatalf("config view output isn't yaml: %v\n%s", err, output)
+	}
+
+	tests := []struct {
+		name    string
+		setting []string
+		want    interface{}
+	}{
+		{
+			name:    "A flag should take precedence over the environment and the config file",
+			setting: []string{"namespace"},
+			want:    "flag-namespace",
+		},
+		{
+			name:    "The environment should take precedence over the config file",
+			setting: []string{"statefulset"},
+			want:    "env-statefulset",
+		},
+		{
+			name:    "The config file should take precedence over defaults",
+			setting: []string{"key-store-secret-name"},
+			want:    "file-secret",
+		},
+		{
+			name:    "A setting set nowhere should keep its default",
+			setting: []string{"vault-port"},
+			want:    8200.0,
+		},
+		{
+			name:    "A command setting should be read from its section of the config file",
+			setting: []string{"status", "output"},
+			want:    "json",
+		},
+		{
+			name:    "A command setting should be read from its prefixed environment variable",
+			setting: []string{"seal", "output"},
+			want:    "yaml",
+		},
+		{
+			name:    "Secrets should be redacted",
+			setting: []string{"token"},
+			want:    redactedValue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := view
+			for _, name := range tt.setting[:len(tt.setting)-1] {
+				section, _ = section[name].(map[string]interface{})
+			}
+			if got := section[tt.setting[len(tt.setting)-1]]; got != tt.want {
+				t.Errorf("%s = %v, want %v", strings.Join(tt.setting, "."), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigRejects(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	tests := []struct {
+		name    string
+		config  string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name:    "An unknown setting should be rejected",
+			config:  "namespce: vault\n",
+			wantErr: "unknown setting namespce",
+		},
+		{
+			name:    "An unknown command setting should be rejected",
+			config:  "status:\n  outptu: json\n",
+			wantErr: "unknown setting status.outptu",
+		},
+		{
+			name:    "An invalid environment value should be rejected",
+			config:  "namespace: vault\n",
+			env:     map[string]string{"VAULT_HANDLER_VAULT_PORT": "http"},
+			wantErr: "VAULT_HANDLER_VAULT_PORT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			_, err := executeRoot(t, "config", "view", "--config", writeConfigFile(t, tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("config view error = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
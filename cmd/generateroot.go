@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	vault "github.com/kubefirst/vault-handler/internal/vault"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vaultGenerateRootOpts *vault.VaultGenerateRootExecutionOptions = &vault.VaultGenerateRootExecutionOptions{}
+)
+
+// generateRootCmd represents the generate-root command
+var generateRootCmd = &cobra.Command{
+	Use:   "generate-root",
+	Short: "Generate a new root token from the stored shares",
+	Long: `Generate a new root token on the raft leader by submitting the stored unseal
+shares, or recovery shares when vault is auto unsealed - the one time password
+is handled internally and the decoded token is written to the key store or
+printed to stdout
+
+With --ttl-revoke the command waits for the duration, or until interrupted,
+then revokes the token and puts the previously stored root token back`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		vaultClient := &vault.Conf
+		_, clientset := createKubeConfig(vaultGenerateRootOpts.KubeInClusterConfig)
+		defer vault.Conf.Close()
+		err := vaultClient.GenerateRoot(ctx, clientset, vaultGenerateRootOpts)
+		if err != nil {
+			log.Fatalf("error generating a root token: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(generateRootCmd)
+
+	generateRootCmd.Flags().StringVar(&vaultGenerateRootOpts.Output, "output", vault.InitOutputKeyStore, "where to write the root token - key-store (default) or stdout")
+	generateRootCmd.Flags().DurationVar(&vaultGenerateRootOpts.TTLRevoke, "ttl-revoke", 0, "revoke the root token after this duration, waiting until then - disabled by default")
+	generateRootCmd.Flags().StringVar(&vault.Conf.UnsealKeysFile, "unseal-keys-file", "", "path to a file with custodian decrypted unseal or recovery shares, one per line - required when shares were pgp encrypted")
+	generateRootCmd.Flags().BoolVar(&vaultGenerateRootOpts.KubeInClusterConfig, "use-kubeconfig-in-cluster", true, "kube config type - in-cluster (default), set to false to use local")
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	vault "github.com/kubefirst/vault-handler/internal/vault"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vaultHardenOpts *vault.VaultHardenExecutionOptions = &vault.VaultHardenExecutionOptions{}
+)
+
+// hardenCmd represents the harden command
+var hardenCmd = &cobra.Command{
+	Use:   "harden",
+	Short: "Replace the stored root token with an admin auth method",
+	Long: `Use the stored root token to enable an admin auth method, userpass or
+kubernetes, write an admin policy and create the admin identity - once the
+identity logs in with the policy attached, the root token is revoked and
+removed from the key store, keeping the unseal shares
+
+A generated userpass password is written to --admin-secret-name unless
+VAULT_HANDLER_ADMIN_PASSWORD is set`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		vaultClient := &vault.Conf
+		_, clientset := createKubeConfig(vaultHardenOpts.KubeInClusterConfig)
+		defer vault.Conf.Close()
+		err := vaultClient.Harden(ctx, clientset, vaultHardenOpts)
+		if err != nil {
+			log.Fatalf("error hardening vault: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hardenCmd)
+
+	hardenCmd.Flags().StringVar(&vaultHardenOpts.AuthMethod, "auth-method", vault.AuthMethodUserpass, "admin auth method - userpass (default) or kubernetes")
+	hardenCmd.Flags().StringVar(&vaultHardenOpts.AuthPath, "auth-path", "", "path to enable the admin auth method at - defaults to the method name")
+	hardenCmd.Flags().StringVar(&vaultHardenOpts.PolicyName, "policy-name", vault.AdminPolicyName, "name of the admin policy")
+	hardenCmd.Flags().StringVar(&vaultHardenOpts.PolicyFile, "policy-file", "", "path to an HCL file replacing the default admin policy, which grants every capability")
+	hardenCmd.Flags().StringVar(&vaultHardenOpts.AdminName, "admin-name", vault.AdminName, "name of the admin userpass user or kubernetes auth role")
+	hardenCmd.Flags().StringVar(&vaultHardenOpts.AdminSecretName, "admin-secret-name", vault.AdminSecretName, "Secret in the vault namespace a generated userpass password is written to")
+	hardenCmd.Flags().StringVar(&vaultHardenOpts.ServiceAccount, "service-account", "", "service account bound to the kubernetes auth role - required with --auth-method kubernetes")
+	hardenCmd.Flags().StringVar(&vaultHardenOpts.ServiceAccountNamespace, "service-account-namespace", "", "namespace of the service account - defaults to the vault namespace")
+	hardenCmd.Flags().StringVar(&vaultHardenOpts.KubernetesHost, "kubernetes-host", vault.KubernetesHost, "API server address written to the kubernetes auth config when it has none")
+	hardenCmd.Flags().DurationVar(&vaultHardenOpts.TokenTTL, "token-ttl", vault.AdminTokenTTL, "ttl of tokens issued to the admin identity")
+	hardenCmd.Flags().BoolVar(&vaultHardenOpts.KubeInClusterConfig, "use-kubeconfig-in-cluster", true, "kube config type - in-cluster (default), set to false to use local")
+}
@@ -4,31 +4,52 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	"fmt"
-
+	vault "github.com/kubefirst/vault-handler/internal/vault"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+var (
+	vaultInitOpts *vault.VaultInitExecutionOptions = &vault.VaultInitExecutionOptions{}
+)
+
 // initCmd represents the init command
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize a vault instance",
-	Long:  `Initialize a vault instance`,
+	Long: `Initialize a vault instance using shamir seals and persist the initialization
+data to the configured key store or stdout - vault is left sealed
+
+With --auto-unseal, a vault whose seal unseals it, such as a cloud kms or
+transit seal, is initialized with recovery keys instead and its raft followers
+are joined once the leader is unsealed`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("init called")
+		vaultClient := &vault.Conf
+		_, clientset := createKubeConfig(vaultInitOpts.KubeInClusterConfig)
+		defer vault.Conf.Close()
+		_, err := vaultClient.InitRaftLeader(clientset, vaultInitOpts)
+		if err != nil {
+			log.Fatalf("error initializing vault raft leader: %s", err)
+		}
+		log.Info("vault initialized successfully!")
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(initCmd)
 
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// initCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// initCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	initCmd.Flags().IntVar(&vaultInitOpts.SecretShares, "secret-shares", vault.SecretShares, "number of unseal key shares to generate")
+	initCmd.Flags().IntVar(&vaultInitOpts.SecretThreshold, "secret-threshold", vault.SecretThreshold, "number of unseal key shares required to unseal vault")
+	initCmd.Flags().BoolVar(&vaultInitOpts.AutoUnseal, "auto-unseal", false, "initialize a vault using an auto unseal seal with recovery keys, then join its raft followers")
+	initCmd.Flags().IntVar(&vaultInitOpts.RecoveryShares, "recovery-shares", vault.RecoveryShares, "number of recovery key shares to generate with --auto-unseal")
+	initCmd.Flags().IntVar(&vaultInitOpts.RecoveryThreshold, "recovery-threshold", vault.RecoveryThreshold, "number of recovery key shares required for recovery operations with --auto-unseal")
+	initCmd.Flags().DurationVar(&vault.Conf.RaftJoinTimeout, "raft-join-timeout", vault.RaftJoinTimeout, "time each node has to auto unseal and become a healthy raft voter with --auto-unseal")
+	initCmd.Flags().BoolVar(&vault.Conf.SkipRaftVerification, "skip-raft-verification", false, "join followers without verifying they become healthy raft voters with --auto-unseal, which otherwise requires a token")
+	initCmd.Flags().StringSliceVar(&vaultInitOpts.PGPKeys, "pgp-keys", []string{}, "comma separated base64 encoded pgp public keys or keybase:<user> entries, one per share - base64 keys are recorded as custodians key-1, key-2 and so on")
+	initCmd.Flags().StringSliceVar(&vaultInitOpts.PGPKeyFiles, "pgp-key-files", []string{}, "comma separated paths to armored or binary pgp public keys, one per share - each file name identifies its custodian")
+	initCmd.Flags().StringVar(&vaultInitOpts.PGPKeysConfigMap, "pgp-keys-configmap", "", "name of a ConfigMap in --namespace holding one pgp public key per custodian, keyed by custodian")
+	initCmd.Flags().StringVar(&vaultInitOpts.RootTokenPGPKey, "root-token-pgp-key", "", "base64 encoded pgp public key or keybase:<user> entry used to encrypt the root token")
+	initCmd.Flags().StringVar(&vaultInitOpts.RootTokenPGPKeyFile, "root-token-pgp-key-file", "", "path to an armored or binary pgp public key used to encrypt the root token")
+	initCmd.Flags().StringVar(&vaultInitOpts.Output, "output", vault.InitOutputKeyStore, "where to persist initialization data - key-store (default) or stdout")
+	initCmd.Flags().BoolVar(&vaultInitOpts.KubeInClusterConfig, "use-kubeconfig-in-cluster", true, "kube config type - in-cluster (default), set to false to use local")
 }
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	vault "github.com/kubefirst/vault-handler/internal/vault"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vaultRekeyOpts *vault.VaultRekeyExecutionOptions = &vault.VaultRekeyExecutionOptions{}
+)
+
+// rekeyCmd represents the rekey command
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Rotate the unseal keys of a vault instance",
+	Long: `Rotate the unseal keys of the raft leader with a shamir rekey, submitting the
+stored unseal shares up to the current threshold, and atomically replace the
+stored initialization data with the new shares
+
+The previous initialization data is backed up first and kept until the new
+shares are confirmed - with --verify (default) vault keeps accepting the
+previous shares until a threshold of the new shares is submitted back, and
+the backup is restored if verification fails - otherwise the backup is
+removed once unseal or watch unseal vault with the new shares`,
+	Run: func(cmd *cobra.Command, args []string) {
+		vaultClient := &vault.Conf
+		_, clientset := createKubeConfig(vaultRekeyOpts.KubeInClusterConfig)
+		defer vault.Conf.Close()
+		err := vaultClient.Rekey(clientset, vaultRekeyOpts)
+		if err != nil {
+			log.Fatalf("error rekeying vault: %s", err)
+		}
+		if !vaultClient.DryRun {
+			log.Info("vault rekeyed successfully!")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rekeyCmd)
+
+	rekeyCmd.Flags().IntVar(&vaultRekeyOpts.SecretShares, "secret-shares", vault.SecretShares, "number of unseal key shares to generate")
+	rekeyCmd.Flags().IntVar(&vaultRekeyOpts.SecretThreshold, "secret-threshold", vault.SecretThreshold, "number of unseal key shares required to unseal vault")
+	rekeyCmd.Flags().BoolVar(&vaultRekeyOpts.Verify, "verify", true, "verify the new shares with vault before the previous shares stop being accepted")
+	rekeyCmd.Flags().BoolVar(&vaultRekeyOpts.KubeInClusterConfig, "use-kubeconfig-in-cluster", true, "kube config type - in-cluster (default), set to false to use local")
+}
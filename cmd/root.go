@@ -2,8 +2,24 @@ package cmd
 
 import (
 	"os"
+	"strings"
 
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	"github.com/kubefirst/vault-handler/internal/logging"
+	vault "github.com/kubefirst/vault-handler/internal/vault"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	logLevel  string
+	logFormat string
+	// events and eventComponent control the Kubernetes Events recorded
+	// against vault Pods
+	events         bool
+	eventComponent string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -26,14 +42,64 @@ func Execute() {
 	}
 }
 
+// createKubeConfig returns the Kubernetes api configuration and clientset and
+// enables Kubernetes Events - when run outside the cluster vault Pods are
+// reached through port-forwards - callers release both with vault.Conf.Close
+func createKubeConfig(inCluster bool) (*rest.Config, *kubernetes.Clientset) {
+	restconfig, clientset, _ := kubernetesinternal.CreateKubeConfig(inCluster)
+	if !inCluster {
+		vault.Conf.EnablePortForward(restconfig)
+	}
+	if events {
+		vault.Conf.EnableEvents(clientset, eventComponent)
+	}
+	return restconfig, clientset
+}
+
+// configureLogging applies the log flags, keeps supplied tokens out of logs and
+// adds the command and namespace to every log entry
+func configureLogging(cmd *cobra.Command) error {
+	if err := logging.Configure(logLevel, logFormat); err != nil {
+		return err
+	}
+	logging.Redact(vault.Conf.Token, os.Getenv("VAULT_TOKEN"))
+	logging.SetDefaultFields(log.Fields{
+		"phase":     strings.Join(commandPath(cmd), " "),
+		"namespace": vault.Conf.Namespace,
+	})
+	return nil
+}
+
 func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.vault-handler.yaml)")
-
-	// Cobra also supports local flags, which will only run
-	// when this action is called directly.
-	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", log.InfoLevel.String(), "log level - trace, debug, info (default), warn, error, fatal or panic")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatText, "log format - text (default) or json - unseal keys and tokens are redacted from both")
+	rootCmd.PersistentFlags().BoolVar(&events, "events", true, "record Kubernetes Events against vault Pods and their StatefulSet for init, unseal and raft join actions")
+	rootCmd.PersistentFlags().StringVar(&eventComponent, "event-component", vault.EventComponent, "component reported as the source of Kubernetes Events")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file - defaults to $HOME/.vault-handler.yaml, then /etc/vault-handler/config.yaml")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.Namespace, "namespace", vault.VaultNamespace, "namespace that the vault StatefulSet runs in")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.StatefulSetName, "statefulset", vault.VaultStatefulSetName, "name of the vault StatefulSet whose replicas are unsealed and joined")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.Token, "token", "", "vault token for authenticated requests - defaults to VAULT_TOKEN, then the stored root token")
+	rootCmd.PersistentFlags().SetAnnotation("token", secretAnnotation, []string{"true"})
+	rootCmd.PersistentFlags().IntVar(&vault.Conf.Port, "vault-port", vault.VaultPort, "port the vault API listens on in every Pod")
+	rootCmd.PersistentFlags().StringVar((*string)(&vault.Conf.PodCondition), "pod-wait-condition", string(kubernetesinternal.PodRunning), "condition a vault Pod must meet before it is used - pod-ip, running or vault-started")
+	rootCmd.PersistentFlags().DurationVar(&vault.Conf.PodWaitTimeout, "pod-wait-timeout", vault.PodWaitTimeout, "time a vault Pod has to meet --pod-wait-condition")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.RaftLeaderAddress, "raft-leader-address", "", "address raft followers join the leader at - defaults to the leader Pod behind the StatefulSet's Service")
+	rootCmd.PersistentFlags().BoolVar(&vault.Conf.DryRun, "dry-run", false, "print the actions that would be taken without writing to vault or kubernetes - discovery and health checks still run")
+	rootCmd.PersistentFlags().BoolVar(&vault.Conf.TLS.Enabled, "tls", false, "reach vault listeners over https")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.TLS.CACertFile, "tls-ca-cert", "", "path to a PEM encoded CA bundle used to verify vault listeners")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.TLS.ClientCertFile, "tls-client-cert", "", "path to a PEM encoded client certificate presented to vault listeners")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.TLS.ClientKeyFile, "tls-client-key", "", "path to the PEM encoded key for --tls-client-cert")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.TLS.Secret, "tls-secret", "", "name of a Secret in --namespace holding ca.crt and, optionally, tls.crt and tls.key - files take precedence")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.TLS.ServerName, "tls-server-name", "", "server name verified against vault certificates instead of the Pod IP")
+	rootCmd.PersistentFlags().BoolVar(&vault.Conf.TLS.SkipVerify, "tls-skip-verify", false, "skip verification of vault certificates")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.KeyStore.Type, "key-store", vault.KeyStoreKubernetes, "where vault initialization data is stored - kubernetes (default), file, or age")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.KeyStore.Namespace, "key-store-namespace", "", "namespace of the kubernetes key store Secret - defaults to --namespace")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.KeyStore.SecretName, "key-store-secret-name", vault.VaultSecretName, "name of the kubernetes key store Secret")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.KeyStore.RootTokenKey, "key-store-root-token-key", vault.VaultRootTokenKey, "kubernetes key store Secret key holding the root token")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.KeyStore.UnsealKeyPrefix, "key-store-unseal-key-prefix", vault.VaultUnsealKeyPrefix, "kubernetes key store Secret key prefix for unseal keys, suffixed with the share number")
+	rootCmd.PersistentFlags().StringVar(&vault.Conf.KeyStore.Path, "key-store-path", "", "path of the file or age key store - the age passphrase is read from "+vault.KeyStorePassphraseEnv)
 }
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	vault "github.com/kubefirst/vault-handler/internal/vault"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vaultSealOpts *vault.VaultSealExecutionOptions = &vault.VaultSealExecutionOptions{}
+	sealOutput    string
+	sealYes       bool
+)
+
+// sealCmd represents the seal command
+var sealCmd = &cobra.Command{
+	Use:   "seal",
+	Short: "Seal every vault instance at once",
+	Long: `Seal every vault StatefulSet Pod concurrently in an emergency, authenticating
+with --token, VAULT_TOKEN or the stored root token - standby nodes are retried
+until they become active and seal, and a per node result is reported
+
+Asks for confirmation unless --yes is set, and exits non-zero if any node
+isn't confirmed sealed`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := validateOutput(sealOutput); err != nil {
+			log.Fatal(err)
+		}
+		if !sealYes && !vault.Conf.DryRun && !confirmSeal(os.Stdin, os.Stderr) {
+			log.Fatal("seal aborted")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		vaultClient := &vault.Conf
+		_, clientset := createKubeConfig(vaultSealOpts.KubeInClusterConfig)
+		defer vault.Conf.Close()
+		results, err := vaultClient.SealRaftCluster(ctx, clientset, vaultSealOpts)
+		if err != nil {
+			log.Fatalf("error sealing vault: %s", err)
+		}
+		err = vault.WriteSealResults(os.Stdout, results, sealOutput)
+		if err != nil {
+			log.Fatalf("error writing seal results: %s", err)
+		}
+		for _, result := range results {
+			if !result.Sealed() && !vault.Conf.DryRun {
+				// os.Exit skips deferred calls
+				vault.Conf.Close()
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+// confirmSeal asks whether every node should be sealed, accepting y or yes
+func confirmSeal(in io.Reader, out io.Writer) bool {
+	fmt.Fprintf(out, "Seal every node of StatefulSet %s in Namespace %s? Vault stays unavailable until it is unsealed [y/N]: ", vault.Conf.StatefulSetName, vault.Conf.Namespace)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(sealCmd)
+
+	sealCmd.Flags().BoolVarP(&sealYes, "yes", "y", false, "seal without asking for confirmation")
+	sealCmd.Flags().DurationVar(&vaultSealOpts.Timeout, "timeout", vault.SealTimeout, "time every node is retried until it reports sealed")
+	sealCmd.Flags().StringVarP(&sealOutput, "output", "o", vault.OutputTable, "output format - table (default), json, or yaml")
+	sealCmd.Flags().BoolVar(&vaultSealOpts.KubeInClusterConfig, "use-kubeconfig-in-cluster", true, "kube config type - in-cluster (default), set to false to use local")
+}
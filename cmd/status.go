@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	vault "github.com/kubefirst/vault-handler/internal/vault"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statusOutput              string
+	statusKubeInClusterConfig bool
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report the seal, init and raft state of vault instances",
+	Long: `Report the Pod IP and phase, health, unseal progress and raft role of every
+vault StatefulSet Pod - exits non-zero if any node is sealed or unreachable`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := validateOutput(statusOutput); err != nil {
+			log.Fatal(err)
+		}
+
+		vaultClient := &vault.Conf
+		_, clientset := createKubeConfig(statusKubeInClusterConfig)
+		defer vault.Conf.Close()
+		statuses, err := vaultClient.RaftClusterStatus(clientset)
+		if err != nil {
+			log.Fatalf("error retrieving vault status: %s", err)
+		}
+		err = vault.WriteNodeStatus(os.Stdout, statuses, statusOutput)
+		if err != nil {
+			log.Fatalf("error writing vault status: %s", err)
+		}
+		if code := statusExitCode(statuses); code != 0 {
+			// os.Exit skips deferred calls
+			vault.Conf.Close()
+			os.Exit(code)
+		}
+	},
+}
+
+// validateOutput checks that an output format is supported before any node is
+// queried
+func validateOutput(output string) error {
+	switch output {
+	case vault.OutputTable, vault.OutputJSON, vault.OutputYAML:
+		return nil
+	default:
+		return fmt.Errorf("unsupported output %q, must be one of %s, %s or %s", output, vault.OutputTable, vault.OutputJSON, vault.OutputYAML)
+	}
+}
+
+// statusExitCode returns 1 when any node is sealed, uninitialized or
+// unreachable, and 0 otherwise
+func statusExitCode(statuses []vault.NodeStatus) int {
+	for _, status := range statuses {
+		if !status.Healthy() {
+			return 1
+		}
+	}
+	return 0
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", vault.OutputTable, "output format - table (default), json, or yaml")
+	statusCmd.Flags().BoolVar(&statusKubeInClusterConfig, "use-kubeconfig-in-cluster", true, "kube config type - in-cluster (default), set to false to use local")
+}
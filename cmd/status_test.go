@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"testing"
+
+	vault "github.com/kubefirst/vault-handler/internal/vault"
+)
+
+func TestValidateOutput(t *testing.T) {
+	for _, output := range []string{vault.OutputTable, vault.OutputJSON, vault.OutputYAML} {
+		if err := validateOutput(output); err != nil {
+			t.Errorf("validateOutput(%q) error = %v", output, err)
+		}
+	}
+	if err := validateOutput("csv"); err == nil {
+		t.Error("validateOutput(\"csv\") succeeded, want an error")
+	}
+}
+
+func TestStatusExitCode(t *testing.T) {
+	unsealed := vault.NodeStatus{Node: "vault-0", HealthResponse: vault.HealthResponse{Initialized: true}}
+	sealed := vault.NodeStatus{Node: "vault-1", HealthResponse: vault.HealthResponse{Initialized: true, Sealed: true}}
+	unreachable := vault.NodeStatus{Node: "vault-2", Error: "pod has no IP"}
+
+	tests := []struct {
+		name     string
+		statuses []vault.NodeStatus
+		want     int
+	}{
+		{
+			name:     "Every node unsealed should exit zero",
+			statuses: []vault.NodeStatus{unsealed, unsealed},
+		},
+		{
+			name:     "Any sealed node should exit non-zero",
+			statuses: []vault.NodeStatus{unsealed, sealed},
+			want:     1,
+		},
+		{
+			name:     "Any unreachable node should exit non-zero",
+			statuses: []vault.NodeStatus{unreachable, unsealed},
+			want:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statusExitCode(tt.statuses); got != tt.want {
+				t.Errorf("statusExitCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	vault "github.com/kubefirst/vault-handler/internal/vault"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vaultTransitOpts *vault.VaultTransitExecutionOptions = &vault.VaultTransitExecutionOptions{}
+)
+
+// transitCmd represents the transit command
+var transitCmd = &cobra.Command{
+	Use:   "transit",
+	Short: "Manage a core vault instance providing transit auto unseal",
+	Long:  `Manage a core vault instance providing transit auto unseal`,
+}
+
+// transitBootstrapCmd represents the transit bootstrap command
+var transitBootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Bootstrap a core vault instance for transit auto unseal",
+	Long: `Initialize and unseal a core vault instance, enable the transit engine and
+create the auto unseal key, a policy scoped to it, and a periodic token holding
+that policy - the token is written to a Secret for the main vault cluster's
+seal "transit" stanza`,
+	Run: func(cmd *cobra.Command, args []string) {
+		vaultClient := &vault.Conf
+		restconfig, clientset := createKubeConfig(vaultTransitOpts.KubeInClusterConfig)
+		defer vault.Conf.Close()
+		if vaultTransitOpts.SecretNamespace == "" {
+			vaultTransitOpts.SecretNamespace = vaultClient.Namespace
+		}
+		err := vaultClient.BootstrapTransit(clientset, restconfig, vaultTransitOpts)
+		if err != nil {
+			log.Fatalf("error bootstrapping transit auto unseal: %s", err)
+		}
+		log.Info("transit auto unseal bootstrapped successfully!")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(transitCmd)
+	transitCmd.AddCommand(transitBootstrapCmd)
+
+	transitBootstrapCmd.Flags().StringVar(&vault.Conf.Config.Address, "address", vault.Conf.Config.Address, "address of the core vault instance - defaults to VAULT_ADDR")
+	transitBootstrapCmd.Flags().IntVar(&vault.Conf.SecretShares, "secret-shares", vault.SecretShares, "number of unseal key shares to generate when the core instance is not yet initialized")
+	transitBootstrapCmd.Flags().IntVar(&vault.Conf.SecretThreshold, "secret-threshold", vault.SecretThreshold, "number of unseal key shares required to unseal the core instance when it is not yet initialized")
+	transitBootstrapCmd.Flags().StringVar(&vaultTransitOpts.CoreStatefulSetName, "core-statefulset", vault.TransitCoreStatefulSetName, "name of the StatefulSet running the core instance, port-forwarded to when running outside the cluster")
+	transitBootstrapCmd.Flags().StringVar(&vaultTransitOpts.CoreKeyStoreSecretName, "core-key-store-secret-name", vault.TransitCoreKeyStoreSecretName, "name of the kubernetes key store Secret holding the core instance's initialization data")
+	transitBootstrapCmd.Flags().StringVar(&vaultTransitOpts.CoreKeyStorePath, "core-key-store-path", "", "path of the file or age key store holding the core instance's initialization data")
+	transitBootstrapCmd.Flags().StringVar(&vaultTransitOpts.Mount, "transit-mount", vault.TransitMount, "path the transit engine is enabled at")
+	transitBootstrapCmd.Flags().StringVar(&vaultTransitOpts.KeyName, "key-name", vault.TransitKeyName, "name of the transit key used for auto unseal")
+	transitBootstrapCmd.Flags().StringVar(&vaultTransitOpts.PolicyName, "policy-name", vault.TransitPolicyName, "name of the policy scoped to the transit key")
+	transitBootstrapCmd.Flags().DurationVar(&vaultTransitOpts.TokenPeriod, "token-period", vault.TransitTokenPeriod, "renewal period of the transit unseal token")
+	transitBootstrapCmd.Flags().StringVar(&vaultTransitOpts.SecretName, "token-secret-name", vault.TransitTokenSecretName, "name of the Secret the transit unseal token is written to")
+	transitBootstrapCmd.Flags().StringVar(&vaultTransitOpts.SecretNamespace, "token-secret-namespace", "", "namespace of the transit unseal token Secret - defaults to --namespace")
+	transitBootstrapCmd.Flags().StringVar(&vaultTransitOpts.SecretKey, "token-secret-key", vault.TransitTokenSecretKey, "key of the transit unseal token within its Secret")
+	addUnsealFlags(transitBootstrapCmd)
+	transitBootstrapCmd.Flags().BoolVar(&vaultTransitOpts.KubeInClusterConfig, "use-kubeconfig-in-cluster", true, "kube config type - in-cluster (default), set to false to use local")
+}
@@ -4,7 +4,7 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	"github.com/kubefirst/vault-handler/internal/metrics"
 	vault "github.com/kubefirst/vault-handler/internal/vault"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -21,7 +21,8 @@ var unsealCmd = &cobra.Command{
 	Long:  `Unseal a vault instance`,
 	Run: func(cmd *cobra.Command, args []string) {
 		vaultClient := &vault.Conf
-		restconfig, clientset, _ := kubernetesinternal.CreateKubeConfig(true)
+		restconfig, clientset := createKubeConfig(vaultUnsealOpts.KubeInClusterConfig)
+		defer vault.Conf.Close()
 		err := vaultClient.UnsealRaftLeader(clientset, restconfig)
 		if err != nil {
 			log.Fatalf("error unsealing vault raft leader: %s", err)
@@ -32,6 +33,9 @@ var unsealCmd = &cobra.Command{
 				log.Fatalf("error unsealing vault raft followers: %s", err)
 			}
 		}
+		if !vaultClient.DryRun {
+			metrics.RecordReconcile()
+		}
 		log.Info("vault initialized and unsealed successfully!")
 	},
 }
@@ -39,6 +43,20 @@ var unsealCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(unsealCmd)
 
-	unsealCmd.Flags().BoolVar(&vaultUnsealOpts.UnsealLeaderOnly, "leader-only", false, "unseal only the raft leader - false (default) - true to only init and unseal vault-0")
+	unsealCmd.Flags().BoolVar(&vaultUnsealOpts.UnsealLeaderOnly, "leader-only", false, "unseal only the raft leader - false (default) - true to only init and unseal the first replica")
+	unsealCmd.Flags().IntVar(&vault.Conf.SecretShares, "secret-shares", vault.SecretShares, "number of unseal key shares to generate when vault is not yet initialized")
+	unsealCmd.Flags().IntVar(&vault.Conf.SecretThreshold, "secret-threshold", vault.SecretThreshold, "number of unseal key shares required to unseal vault when it is not yet initialized")
+	addUnsealFlags(unsealCmd)
+	unsealCmd.Flags().DurationVar(&vault.Conf.RaftJoinTimeout, "raft-join-timeout", vault.RaftJoinTimeout, "time each joined follower has to become a healthy raft voter")
+	unsealCmd.Flags().BoolVar(&vault.Conf.SkipRaftVerification, "skip-raft-verification", false, "join followers without verifying they become healthy raft voters, which otherwise requires a token")
 	unsealCmd.Flags().BoolVar(&vaultUnsealOpts.KubeInClusterConfig, "use-kubeconfig-in-cluster", true, "kube config type - in-cluster (default), set to false to use local")
 }
+
+// addUnsealFlags adds the flags controlling how unseal shares are submitted
+func addUnsealFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&vault.Conf.UnsealKeysFile, "unseal-keys-file", "", "path to a file with custodian decrypted unseal shares, one per line - required when shares were pgp encrypted")
+	cmd.Flags().DurationVar(&vault.Conf.Retry.Timeout, "unseal-timeout", vault.UnsealTimeout, "timeout for each unseal share submission")
+	cmd.Flags().IntVar(&vault.Conf.Retry.MaxAttempts, "max-attempts", vault.UnsealMaxAttempts, "maximum attempts to submit each unseal share before giving up")
+	cmd.Flags().DurationVar(&vault.Conf.Retry.InitialBackoff, "unseal-backoff", vault.UnsealInitialBackoff, "initial delay between unseal share attempts, doubled with jitter after every failure")
+	cmd.Flags().DurationVar(&vault.Conf.Retry.MaxBackoff, "unseal-max-backoff", vault.UnsealMaxBackoff, "maximum delay between unseal share attempts")
+}
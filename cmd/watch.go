@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kubefirst/vault-handler/internal/metrics"
+	vault "github.com/kubefirst/vault-handler/internal/vault"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vaultWatchOpts   *vault.VaultWatchExecutionOptions = &vault.VaultWatchExecutionOptions{}
+	watchMetricsAddr string
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Keep vault instances unsealed",
+	Long: `Watch the vault StatefulSet Pods and unseal any node that restarts sealed
+using the stored unseal keys - runs until interrupted`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if watchMetricsAddr != "" {
+			if err := metrics.Serve(watchMetricsAddr); err != nil {
+				log.Fatalf("error serving metrics: %s", err)
+			}
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		vaultClient := &vault.Conf
+		_, clientset := createKubeConfig(vaultWatchOpts.KubeInClusterConfig)
+		defer vault.Conf.Close()
+		err := vaultClient.WatchRaftNodes(ctx, clientset, vaultWatchOpts)
+		if err != nil {
+			log.Fatalf("error watching vault raft nodes: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().DurationVar(&vaultWatchOpts.Interval, "interval", vault.CheckInterval, "rate at which vault health is checked, and the initial delay before retrying a failed unseal")
+	watchCmd.Flags().DurationVar(&vaultWatchOpts.MaxBackoff, "max-backoff", vault.MaxUnsealBackoff, "maximum delay between failed unseal attempts against a single node")
+	watchCmd.Flags().StringVar(&watchMetricsAddr, "metrics-addr", "", "address to serve prometheus metrics at "+metrics.Path+" on, such as :9090 - disabled by default")
+	addUnsealFlags(watchCmd)
+	watchCmd.Flags().BoolVar(&vaultWatchOpts.KubeInClusterConfig, "use-kubeconfig-in-cluster", true, "kube config type - in-cluster (default), set to false to use local")
+}
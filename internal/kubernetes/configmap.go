@@ -2,37 +2,34 @@ package kubernetes
 
 import (
 	"context"
-	"errors"
 	"fmt"
 
 	log "github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
-// ReadConfigMapV2
-func ReadConfigMapV2(inCluster bool, namespace string, configMapName string) (map[string]string, error) {
-	_, clientset, _ := CreateKubeConfig(inCluster)
-
+// ReadConfigMapV2 reads the content of a Kubernetes ConfigMap
+func ReadConfigMapV2(clientset kubernetes.Interface, namespace string, configMapName string) (map[string]string, error) {
 	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configMapName, metav1.GetOptions{})
 	if err != nil {
-		return map[string]string{}, errors.New(fmt.Sprintf("error getting ConfigMap: %s\n", err))
+		return map[string]string{}, fmt.Errorf("error getting ConfigMap %s in Namespace %s: %s", configMapName, namespace, err)
 	}
 
-	parsedSecretData := make(map[string]string)
+	parsedConfigMapData := make(map[string]string)
 	for key, value := range configMap.Data {
-		parsedSecretData[key] = string(value)
+		parsedConfigMapData[key] = value
 	}
 
-	return parsedSecretData, nil
+	return parsedConfigMapData, nil
 }
 
-// UpdateConfigMapV2
-func UpdateConfigMapV2(inCluster bool, namespace, configMapName string, key string, value string) error {
-	_, clientset, _ := CreateKubeConfig(inCluster)
-
+// UpdateConfigMapV2 replaces the content of an existing Kubernetes ConfigMap
+// with a single key
+func UpdateConfigMapV2(clientset kubernetes.Interface, namespace, configMapName string, key string, value string) error {
 	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configMapName, metav1.GetOptions{})
 	if err != nil {
-		return errors.New(fmt.Sprintf("error getting ConfigMap: %s\n", err))
+		return fmt.Errorf("error getting ConfigMap %s in Namespace %s: %s", configMapName, namespace, err)
 	}
 
 	configMap.Data = map[string]string{key: value}
@@ -41,8 +38,10 @@ func UpdateConfigMapV2(inCluster bool, namespace, configMapName string, key stri
 		configMap,
 		metav1.UpdateOptions{},
 	)
-
-	log.Infof("updated ConfigMap %s in Namespace %s\n", configMap.Name, configMap.Namespace)
+	if err != nil {
+		return fmt.Errorf("error updating ConfigMap %s in Namespace %s: %s", configMapName, namespace, err)
+	}
+	log.Infof("updated ConfigMap %s in Namespace %s", configMap.Name, configMap.Namespace)
 
 	return nil
 }
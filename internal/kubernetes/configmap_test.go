@@ -0,0 +1,48 @@
+package kubernetes
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapV2(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "pgp-keys", Namespace: "vault"},
+		Data:       map[string]string{"alice": "a1", "bob": "b2"},
+	}
+	clientset := fake.NewSimpleClientset(configMap)
+
+	got, err := ReadConfigMapV2(clientset, "vault", "pgp-keys")
+	if err != nil {
+		t.Fatalf("ReadConfigMapV2() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, configMap.Data) {
+		t.Errorf("ReadConfigMapV2() = %v, want %v", got, configMap.Data)
+	}
+
+	if err := UpdateConfigMapV2(clientset, "vault", "pgp-keys", "carol", "c3"); err != nil {
+		t.Fatalf("UpdateConfigMapV2() error = %v", err)
+	}
+	updated, err := clientset.CoreV1().ConfigMaps("vault").Get(context.Background(), "pgp-keys", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"carol": "c3"}; !reflect.DeepEqual(updated.Data, want) {
+		t.Errorf("updated ConfigMap data = %v, want %v", updated.Data, want)
+	}
+
+	_, err = ReadConfigMapV2(clientset, "vault", "missing")
+	if err == nil || !strings.Contains(err.Error(), "error getting ConfigMap missing in Namespace vault") {
+		t.Errorf("ReadConfigMapV2() of a missing ConfigMap error = %v", err)
+	}
+	err = UpdateConfigMapV2(clientset, "vault", "missing", "carol", "c3")
+	if err == nil || !strings.Contains(err.Error(), "error getting ConfigMap missing in Namespace vault") {
+		t.Errorf("UpdateConfigMapV2() of a missing ConfigMap error = %v", err)
+	}
+}
@@ -2,63 +2,146 @@ package kubernetes
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
-	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
 )
 
-// ReturnPodObject returns a matching corev1.Pod object based on the filters
-func ReturnPodObject(clientset *kubernetes.Clientset, matchLabel string, matchLabelValue string, namespace string, timeoutSeconds float64) (*corev1.Pod, error) {
-	// Filter
-	podListOptions := metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("%s=%s", matchLabel, matchLabelValue),
+// PodCondition selects when a Pod being waited for is returned
+type PodCondition string
+
+const (
+	// PodIPAssigned is met once the Pod has an IP, even while it is Pending
+	PodIPAssigned PodCondition = "pod-ip"
+	// PodRunning is met once the Pod is Running with an IP
+	PodRunning PodCondition = "running"
+	// VaultContainerStarted is met once the vault container has started -
+	// vault Pods aren't Ready while sealed, so readiness can't be waited for
+	VaultContainerStarted PodCondition = "vault-started"
+
+	// Name of the vault container within vault Pods
+	vaultContainerName string = "vault"
+)
+
+// PodConditions lists every supported PodCondition
+var PodConditions = []PodCondition{PodIPAssigned, PodRunning, VaultContainerStarted}
+
+// Validate returns an error for an unsupported condition
+func (condition PodCondition) Validate() error {
+	for _, supported := range PodConditions {
+		if condition == supported {
+			return nil
+		}
 	}
+	return fmt.Errorf("unsupported Pod condition %q, must be one of %s, %s or %s", condition, PodIPAssigned, PodRunning, VaultContainerStarted)
+}
 
-	// Create watch operation
-	objWatch, err := clientset.
-		CoreV1().
-		Pods(namespace).
-		Watch(context.Background(), podListOptions)
-	if err != nil {
-		log.Fatalf("error when attempting to search for Pod: %s", err)
+// Met returns whether the Pod meets the condition
+func (condition PodCondition) Met(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" {
+		return false
 	}
-	log.Infof("waiting for %s Pod to be created", matchLabelValue)
-
-	objChan := objWatch.ResultChan()
-	for {
-		select {
-		case event, ok := <-objChan:
-			time.Sleep(time.Second * 1)
-			if !ok {
-				// Error if the channel closes
-				log.Fatalf("error waiting for %s Pod to be created: %s", matchLabelValue, err)
+	switch condition {
+	case PodIPAssigned:
+		return true
+	case PodRunning:
+		return pod.Status.Phase == corev1.PodRunning
+	case VaultContainerStarted:
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == vaultContainerName {
+				return status.State.Running != nil && (status.Started == nil || *status.Started)
 			}
-			if event.
-				Object.(*v1.Pod).Status.Phase == "Pending" {
-				spec, err := clientset.CoreV1().Pods(namespace).List(context.Background(), podListOptions)
-				if err != nil {
-					log.Fatalf("error when searching for Pod: %s", err)
-					return nil, err
-				}
-				return &spec.Items[0], nil
-			}
-			if event.
-				Object.(*v1.Pod).Status.Phase == "Running" {
-				spec, err := clientset.CoreV1().Pods(namespace).List(context.Background(), podListOptions)
-				if err != nil {
-					log.Fatalf("error when searching for Pod: %s", err)
-					return nil, err
-				}
-				return &spec.Items[0], nil
-			}
-		case <-time.After(time.Duration(timeoutSeconds) * time.Second):
-			log.Errorf("the Pod was not created within the timeout period")
-			return nil, fmt.Errorf("the Pod was not created within the timeout period")
 		}
 	}
+	return false
+}
+
+// PodWaitError is returned when waiting for a Pod ends before a Pod met the
+// condition, wrapping the context error or the error that ended the wait
+type PodWaitError struct {
+	Namespace string
+	Selector  string
+	Condition PodCondition
+	// LastSeen describes the last matching Pod observed, if any
+	LastSeen string
+	Err      error
+}
+
+func (e *PodWaitError) Error() string {
+	lastSeen := "no matching Pod was seen"
+	if e.LastSeen != "" {
+		lastSeen = "last seen " + e.LastSeen
+	}
+	return fmt.Sprintf("error waiting for a Pod matching %s in Namespace %s to meet condition %s, %s: %s", e.Selector, e.Namespace, e.Condition, lastSeen, e.Err)
+}
+
+func (e *PodWaitError) Unwrap() error {
+	return e.Err
+}
+
+// WaitForPod waits until a Pod matching the label selector meets the
+// condition and returns it
+//
+// Matching Pods are listed and then watched, so a Pod that already meets the
+// condition is returned immediately and a Pod deleted and recreated while
+// waiting is followed - waiting ends with a *PodWaitError when ctx is done
+func WaitForPod(ctx context.Context, clientset kubernetes.Interface, namespace string, selector string, condition PodCondition) (*corev1.Pod, error) {
+	waitError := &PodWaitError{Namespace: namespace, Selector: selector, Condition: condition}
+	if err := condition.Validate(); err != nil {
+		waitError.Err = err
+		return nil, waitError
+	}
+
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector
+			return clientset.CoreV1().Pods(namespace).List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector
+			return clientset.CoreV1().Pods(namespace).Watch(ctx, options)
+		},
+	}
+
+	logger := log.WithFields(log.Fields{"namespace": namespace, "selector": selector})
+	logger.Infof("waiting for a Pod matching %s to meet condition %s", selector, condition)
+	event, err := watchtools.UntilWithSync(ctx, listWatch, &corev1.Pod{}, nil, func(event watch.Event) (bool, error) {
+		pod, ok := event.Object.(*corev1.Pod)
+		if !ok {
+			return false, nil
+		}
+		if event.Type == watch.Deleted {
+			waitError.LastSeen = fmt.Sprintf("Pod %s deleted", pod.Name)
+			logger.Infof("Pod %s was deleted, waiting for it to be recreated", pod.Name)
+			return false, nil
+		}
+		waitError.LastSeen = fmt.Sprintf("Pod %s in phase %s", pod.Name, pod.Status.Phase)
+		return condition.Met(pod), nil
+	})
+	if err != nil {
+		// The watch reports an expired context as a generic timeout
+		if errors.Is(err, wait.ErrWaitTimeout) && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		waitError.Err = err
+		return nil, waitError
+	}
+
+	pod := event.Object.(*corev1.Pod)
+	logger.Infof("Pod %s met condition %s", pod.Name, condition)
+	return pod, nil
+}
+
+// ReadPodV2 returns a Pod by name
+func ReadPodV2(clientset kubernetes.Interface, namespace string, podName string) (*corev1.Pod, error) {
+	return clientset.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
 }
@@ -0,0 +1,100 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWaitForPod(t *testing.T) {
+	started := true
+	pod := func(phase corev1.PodPhase, podIP string, containers ...corev1.ContainerStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-0", Namespace: "vault", Labels: map[string]string{"app": "vault"}},
+			Status:     corev1.PodStatus{Phase: phase, PodIP: podIP, ContainerStatuses: containers},
+		}
+	}
+	vaultRunning := corev1.ContainerStatus{
+		Name:    vaultContainerName,
+		State:   corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		Started: &started,
+	}
+
+	type args struct {
+		existing  *corev1.Pod
+		recreated *corev1.Pod
+		condition PodCondition
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr error
+	}{
+		{
+			name: "A running Pod should be returned without waiting",
+			args: args{existing: pod(corev1.PodRunning, "10.0.0.1"), condition: PodRunning},
+		},
+		{
+			name: "A Pending Pod with an IP should meet the pod-ip condition",
+			args: args{existing: pod(corev1.PodPending, "10.0.0.1"), condition: PodIPAssigned},
+		},
+		{
+			name:    "A Pending Pod without an IP should be waited for until the context ends",
+			args:    args{existing: pod(corev1.PodPending, ""), condition: PodIPAssigned},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "A Pod should be followed when it is deleted and recreated",
+			args: args{
+				existing:  pod(corev1.PodPending, ""),
+				recreated: pod(corev1.PodRunning, "10.0.0.2", vaultRunning),
+				condition: VaultContainerStarted,
+			},
+		},
+		{
+			name:    "A running Pod whose vault container hasn't started shouldn't meet the vault-started condition",
+			args:    args{existing: pod(corev1.PodRunning, "10.0.0.1"), condition: VaultContainerStarted},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(tt.args.existing)
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			if tt.args.recreated != nil {
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					pods := clientset.CoreV1().Pods("vault")
+					_ = pods.Delete(ctx, tt.args.existing.Name, metav1.DeleteOptions{})
+					_, _ = pods.Create(ctx, tt.args.recreated, metav1.CreateOptions{})
+				}()
+			}
+
+			got, err := WaitForPod(ctx, clientset, "vault", "app=vault", tt.args.condition)
+			if tt.wantErr != nil {
+				var waitError *PodWaitError
+				if !errors.As(err, &waitError) || !errors.Is(err, tt.wantErr) {
+					t.Fatalf("WaitForPod() error = %v, want a *PodWaitError wrapping %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WaitForPod() error = %v", err)
+			}
+			want := tt.args.existing
+			if tt.args.recreated != nil {
+				want = tt.args.recreated
+			}
+			if got.Status.PodIP != want.Status.PodIP {
+				t.Errorf("WaitForPod() returned Pod with IP %q, want %q", got.Status.PodIP, want.Status.PodIP)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// PortForward holds an active port-forward to a Pod
+type PortForward struct {
+	// LocalPort is the ephemeral port on 127.0.0.1 forwarded to the Pod
+	LocalPort int
+	stopChan  chan struct{}
+	doneChan  chan struct{}
+	stopOnce  sync.Once
+}
+
+// Stop closes the port-forward and waits for it to shut down
+func (forward *PortForward) Stop() {
+	forward.stopOnce.Do(func() {
+		close(forward.stopChan)
+	})
+	<-forward.doneChan
+}
+
+// Done is closed once the port-forward has shut down, including when the
+// Pod goes away
+func (forward *PortForward) Done() <-chan struct{} {
+	return forward.doneChan
+}
+
+// PortForwardPod forwards an ephemeral local port to a port of the supplied Pod
+func PortForwardPod(clientset kubernetes.Interface, restConfig *rest.Config, pod *corev1.Pod, remotePort int) (*PortForward, error) {
+	roundTripper, upgrader, err := spdy.RoundTripperFor(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	url := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("portforward").
+		URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, url)
+
+	forward := &PortForward{
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+	readyChan := make(chan struct{})
+	forwarder, err := portforward.NewOnAddresses(
+		dialer,
+		[]string{"127.0.0.1"},
+		[]string{fmt.Sprintf("0:%v", remotePort)},
+		forward.stopChan,
+		readyChan,
+		io.Discard,
+		io.Discard,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(forward.doneChan)
+		errChan <- forwarder.ForwardPorts()
+	}()
+
+	select {
+	case <-readyChan:
+	case err := <-errChan:
+		return nil, fmt.Errorf("error port-forwarding to Pod %s: %s", pod.Name, err)
+	}
+
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		forward.Stop()
+		return nil, err
+	}
+	forward.LocalPort = int(ports[0].Local)
+	log.Infof("port-forwarding 127.0.0.1:%v to Pod %s port %v", forward.LocalPort, pod.Name, remotePort)
+
+	return forward, nil
+}
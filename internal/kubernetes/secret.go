@@ -10,7 +10,7 @@ import (
 )
 
 // CreateSecretV2 creates a Kubernetes Secret
-func CreateSecretV2(clientset *kubernetes.Clientset, secret *v1.Secret) error {
+func CreateSecretV2(clientset kubernetes.Interface, secret *v1.Secret) error {
 	_, err := clientset.CoreV1().Secrets(secret.Namespace).Create(
 		context.Background(),
 		secret,
@@ -24,12 +24,10 @@ func CreateSecretV2(clientset *kubernetes.Clientset, secret *v1.Secret) error {
 }
 
 // ReadSecretV2 reads the content of a Kubernetes Secret
-func ReadSecretV2(clientset *kubernetes.Clientset, namespace string, secretName string) (map[string]string, error) {
-
+func ReadSecretV2(clientset kubernetes.Interface, namespace string, secretName string) (map[string]string, error) {
 	secret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
 	if err != nil {
-		log.Errorf("Error getting secret: %s\n", err)
-		return map[string]string{}, nil
+		return map[string]string{}, err
 	}
 
 	parsedSecretData := make(map[string]string)
@@ -39,3 +37,32 @@ func ReadSecretV2(clientset *kubernetes.Clientset, namespace string, secretName
 
 	return parsedSecretData, nil
 }
+
+// GetSecretV2 returns a Kubernetes Secret
+func GetSecretV2(clientset kubernetes.Interface, namespace string, secretName string) (*v1.Secret, error) {
+	return clientset.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
+}
+
+// DeleteSecretV2 deletes a Kubernetes Secret
+func DeleteSecretV2(clientset kubernetes.Interface, namespace string, secretName string) error {
+	err := clientset.CoreV1().Secrets(namespace).Delete(context.Background(), secretName, metav1.DeleteOptions{})
+	if err != nil {
+		return err
+	}
+	log.Infof("deleted Secret %s in Namespace %s", secretName, namespace)
+	return nil
+}
+
+// UpdateSecretV2 replaces the content of an existing Kubernetes Secret
+func UpdateSecretV2(clientset kubernetes.Interface, secret *v1.Secret) error {
+	_, err := clientset.CoreV1().Secrets(secret.Namespace).Update(
+		context.Background(),
+		secret,
+		metav1.UpdateOptions{},
+	)
+	if err != nil {
+		return err
+	}
+	log.Infof("updated Secret %s in Namespace %s", secret.Name, secret.Namespace)
+	return nil
+}
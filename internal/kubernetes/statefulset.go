@@ -0,0 +1,20 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ReturnStatefulSetObject returns a matching appsv1.StatefulSet object
+func ReturnStatefulSetObject(clientset kubernetes.Interface, namespace string, statefulSetName string) (*appsv1.StatefulSet, error) {
+	statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(context.Background(), statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting StatefulSet %s in Namespace %s: %s", statefulSetName, namespace, err)
+	}
+
+	return statefulSet, nil
+}
@@ -0,0 +1,182 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Supported log formats
+	FormatText string = "text"
+	FormatJSON string = "json"
+	// Value written in place of secrets
+	RedactedValue string = "REDACTED"
+	// Shorter values are never registered as secrets, so that redaction
+	// can't mangle ordinary words
+	minSecretLength int = 8
+)
+
+// secretPatterns match secrets that are redacted even when they were never
+// registered - vault tokens and hex encoded key shares
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\bhv[sbr]\.[A-Za-z0-9_\-]{20,}`),
+	regexp.MustCompile(`\b[sbr]\.[A-Za-z0-9]{24}\b`),
+	regexp.MustCompile(`\b[0-9a-fA-F]{64,}\b`),
+}
+
+var (
+	secrets = &secretRegistry{values: map[string]struct{}{}}
+	fields  = &defaultFieldsHook{fields: log.Fields{}}
+)
+
+func init() {
+	log.SetFormatter(&RedactingFormatter{Formatter: &log.TextFormatter{}})
+	log.AddHook(fields)
+}
+
+// Configure sets the level and format of the global logger, keeping secrets
+// redacted in either format
+func Configure(level string, format string) error {
+	parsedLevel, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	var formatter log.Formatter
+	switch format {
+	case FormatText:
+		formatter = &log.TextFormatter{}
+	case FormatJSON:
+		formatter = &log.JSONFormatter{}
+	default:
+		return fmt.Errorf("unsupported log format %q, must be one of %s or %s", format, FormatText, FormatJSON)
+	}
+
+	log.SetLevel(parsedLevel)
+	log.SetFormatter(&RedactingFormatter{Formatter: formatter})
+	return nil
+}
+
+// Redact registers values, such as unseal keys and tokens, that must never
+// appear in a log
+func Redact(values ...string) {
+	secrets.add(values...)
+}
+
+// SetDefaultFields sets fields added to every log entry that doesn't set them itself
+func SetDefaultFields(defaults log.Fields) {
+	fields.set(defaults)
+}
+
+// RedactingFormatter replaces registered secrets and anything that looks like
+// a vault token or key share in the output of another formatter
+type RedactingFormatter struct {
+	Formatter log.Formatter
+}
+
+// Format formats an entry and redacts secrets from the result, covering the
+// message and every field
+func (formatter *RedactingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	content, err := formatter.Formatter.Format(entry)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(RedactString(string(content))), nil
+}
+
+// RedactString returns content with secrets replaced
+func RedactString(content string) string {
+	content = secrets.replace(content)
+	for _, pattern := range secretPatterns {
+		content = pattern.ReplaceAllString(content, RedactedValue)
+	}
+	return content
+}
+
+// secretRegistry holds registered secrets and a replacer for them
+type secretRegistry struct {
+	mu       sync.RWMutex
+	values   map[string]struct{}
+	replacer *strings.Replacer
+}
+
+func (registry *secretRegistry) add(values ...string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	changed := false
+	for _, value := range values {
+		if len(value) < minSecretLength {
+			continue
+		}
+		if _, ok := registry.values[value]; !ok {
+			registry.values[value] = struct{}{}
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	// Longer secrets are replaced first so that a secret containing another
+	// is redacted whole
+	sorted := make([]string, 0, len(registry.values))
+	for value := range registry.values {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, value := range sorted {
+		pairs = append(pairs, value, RedactedValue)
+	}
+	registry.replacer = strings.NewReplacer(pairs...)
+}
+
+func (registry *secretRegistry) replace(content string) string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	if registry.replacer == nil {
+		return content
+	}
+	return registry.replacer.Replace(content)
+}
+
+// defaultFieldsHook adds fields to entries that don't set them
+type defaultFieldsHook struct {
+	mu     sync.RWMutex
+	fields log.Fields
+}
+
+func (hook *defaultFieldsHook) set(defaults log.Fields) {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+
+	hook.fields = log.Fields{}
+	for key, value := range defaults {
+		hook.fields[key] = value
+	}
+}
+
+// Levels applies the hook to every level
+func (hook *defaultFieldsHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire adds the default fields to an entry
+func (hook *defaultFieldsHook) Fire(entry *log.Entry) error {
+	hook.mu.RLock()
+	defer hook.mu.RUnlock()
+
+	for key, value := range hook.fields {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRedactingFormatter(t *testing.T) {
+	unsealKey := "c2FtcGxlLXVuc2VhbC1rZXktc2hhcmUtYmFzZTY0LWVuY29kZWQ="
+	rootToken := "hvs.CAESIJ2tXz6Qb8bAm1pPqzWq0ZbE9tXcK0g4zVuQ7Fh3mS5Q"
+	hexShare := strings.Repeat("ab12", 16) + "ff"
+	Redact(unsealKey)
+
+	type args struct {
+		format string
+		fields log.Fields
+		msg    string
+	}
+	tests := []struct {
+		name string
+		args args
+		leak []string
+	}{
+		{
+			name: "A registered unseal key should be redacted from text messages",
+			args: args{format: FormatText, msg: "passing unseal shard " + unsealKey},
+			leak: []string{unsealKey},
+		},
+		{
+			name: "Unregistered vault tokens and hex shares should be redacted from text fields",
+			args: args{format: FormatText, fields: log.Fields{"token": rootToken, "share": hexShare}, msg: "debugging"},
+			leak: []string{rootToken, hexShare},
+		},
+		{
+			name: "Secrets should be redacted from json messages and fields",
+			args: args{format: FormatJSON, fields: log.Fields{"key": unsealKey}, msg: "token " + rootToken},
+			leak: []string{unsealKey, rootToken},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Configure("info", tt.args.format); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			log.SetOutput(&out)
+			log.WithFields(tt.args.fields).Info(tt.args.msg)
+
+			for _, secret := range tt.leak {
+				if strings.Contains(out.String(), secret) {
+					t.Errorf("log output %q leaks %q", out.String(), secret)
+				}
+			}
+			if !strings.Contains(out.String(), RedactedValue) {
+				t.Errorf("log output %q doesn't mark the redaction", out.String())
+			}
+			if tt.args.format == FormatJSON && !json.Valid(out.Bytes()) {
+				t.Errorf("log output %q isn't valid json", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Prefix of every handler metric
+	namespace string = "vault_handler"
+	// Path metrics are served at
+	Path string = "/metrics"
+)
+
+var (
+	nodeSealed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_sealed",
+		Help:      "Whether a vault node last reported sealed (1) or unsealed (0).",
+	}, []string{"node"})
+	nodeInitialized = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_initialized",
+		Help:      "Whether a vault node last reported initialized (1) or not (0).",
+	}, []string{"node"})
+	unsealAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unseal_attempts_total",
+		Help:      "Unseal attempts started against a sealed vault node.",
+	}, []string{"node"})
+	unsealSuccesses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unseal_successes_total",
+		Help:      "Unseal attempts that left a vault node unsealed.",
+	}, []string{"node"})
+	unsealFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unseal_failures_total",
+		Help:      "Unseal attempts that failed to unseal a vault node.",
+	}, []string{"node"})
+	unsealDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "unseal_duration_seconds",
+		Help:      "Time taken to unseal a vault node, from the first share to unsealed.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"node"})
+	raftJoinDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "raft_join_duration_seconds",
+		Help:      "Time taken for a raft follower to join the leader and be unsealed, and verified as a voter when a token is available.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"node"})
+	lastReconcile = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_reconcile_timestamp_seconds",
+		Help:      "Unix time at which every vault node was last found initialized and unsealed.",
+	})
+)
+
+// Registry holds the handler metrics along with go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		nodeSealed,
+		nodeInitialized,
+		unsealAttempts,
+		unsealSuccesses,
+		unsealFailures,
+		unsealDuration,
+		raftJoinDuration,
+		lastReconcile,
+	)
+}
+
+// Serve exposes the metrics at Path on the supplied address until the process
+// exits, returning an error when the address can't be listened on
+func Serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening for metrics on %s: %s", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Infof("serving metrics at %s%s", listener.Addr(), Path)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("error serving metrics: %s", err)
+		}
+	}()
+	return nil
+}
+
+// RecordHealth records the seal and initialization state a node reported
+func RecordHealth(node string, health *vaultapi.HealthResponse) {
+	nodeSealed.WithLabelValues(node).Set(boolValue(health.Sealed))
+	nodeInitialized.WithLabelValues(node).Set(boolValue(health.Initialized))
+}
+
+// RecordUnsealAttempt counts an unseal attempt against a node and returns a
+// function recording its outcome and duration
+func RecordUnsealAttempt(node string) func(err error) {
+	unsealAttempts.WithLabelValues(node).Inc()
+	start := time.Now()
+	return func(err error) {
+		if err != nil {
+			unsealFailures.WithLabelValues(node).Inc()
+			return
+		}
+		unsealSuccesses.WithLabelValues(node).Inc()
+		unsealDuration.WithLabelValues(node).Observe(time.Since(start).Seconds())
+		nodeSealed.WithLabelValues(node).Set(0)
+	}
+}
+
+// ObserveRaftJoin records the time taken for a follower to join the leader
+func ObserveRaftJoin(node string, start time.Time) {
+	raftJoinDuration.WithLabelValues(node).Observe(time.Since(start).Seconds())
+}
+
+// RecordReconcile records that every node was found initialized and unsealed
+func RecordReconcile() {
+	lastReconcile.SetToCurrentTime()
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUnsealAndJoinMetrics(t *testing.T) {
+	RecordHealth("vault-0", &vaultapi.HealthResponse{Initialized: true, Sealed: true})
+	RecordUnsealAttempt("vault-0")(nil)
+	RecordUnsealAttempt("vault-1")(errors.New("invalid key"))
+	RecordUnsealAttempt("vault-1")(nil)
+	ObserveRaftJoin("vault-1", time.Now().Add(-time.Second))
+
+	expected := `
+# HELP vault_handler_node_sealed Whether a vault node last reported sealed (1) or unsealed (0).
+# TYPE vault_handler_node_sealed gauge
+vault_handler_node_sealed{node="vault-0"} 0
+vault_handler_node_sealed{node="vault-1"} 0
+# HELP vault_handler_unseal_attempts_total Unseal attempts started against a sealed vault node.
+# TYPE vault_handler_unseal_attempts_total counter
+vault_handler_unseal_attempts_total{node="vault-0"} 1
+vault_handler_unseal_attempts_total{node="vault-1"} 2
+# HELP vault_handler_unseal_failures_total Unseal attempts that failed to unseal a vault node.
+# TYPE vault_handler_unseal_failures_total counter
+vault_handler_unseal_failures_total{node="vault-1"} 1
+# HELP vault_handler_unseal_successes_total Unseal attempts that left a vault node unsealed.
+# TYPE vault_handler_unseal_successes_total counter
+vault_handler_unseal_successes_total{node="vault-0"} 1
+vault_handler_unseal_successes_total{node="vault-1"} 1
+`
+	err := testutil.GatherAndCompare(Registry, strings.NewReader(expected),
+		"vault_handler_node_sealed",
+		"vault_handler_unseal_attempts_total",
+		"vault_handler_unseal_failures_total",
+		"vault_handler_unseal_successes_total",
+	)
+	if err != nil {
+		t.Errorf("unexpected unseal metrics: %v", err)
+	}
+
+	// Durations vary, so only the observations are counted
+	if got := testutil.CollectAndCount(unsealDuration, "vault_handler_unseal_duration_seconds"); got != 2 {
+		t.Errorf("unseal durations were recorded for %v nodes, want 2", got)
+	}
+	if got := testutil.CollectAndCount(raftJoinDuration, "vault_handler_raft_join_duration_seconds"); got != 1 {
+		t.Errorf("raft join durations were recorded for %v nodes, want 1", got)
+	}
+	if got := testutil.ToFloat64(nodeInitialized.WithLabelValues("vault-0")); got != 1 {
+		t.Errorf("vault_handler_node_initialized = %v, want 1", got)
+	}
+}
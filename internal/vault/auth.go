@@ -0,0 +1,41 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// enableAuthMethod enables an auth method of the given type at path, unless
+// one is already enabled there
+func (conf *VaultConfiguration) enableAuthMethod(vaultClient *vaultapi.Client, methodType string, path string, logger *log.Entry) error {
+	path = strings.Trim(path, "/")
+	methods, err := vaultClient.Sys().ListAuth()
+	if err != nil {
+		return fmt.Errorf("error listing auth methods: %s", err)
+	}
+	if existing, ok := methods[path+"/"]; ok {
+		if existing.Type != methodType {
+			return fmt.Errorf("a %s auth method is already enabled at %s, not %s", existing.Type, path, methodType)
+		}
+		logger.Infof("%s auth method is already enabled at %s", methodType, path)
+		return nil
+	}
+	if conf.plan("enable %s auth method at %s", methodType, path) {
+		return nil
+	}
+	logger.Infof("enabling %s auth method at %s", methodType, path)
+	return vaultClient.Sys().EnableAuthWithOptions(path, &vaultapi.EnableAuthOptions{Type: methodType})
+}
+
+// hasPolicy returns whether an auth response carries the policy
+func hasPolicy(auth *vaultapi.SecretAuth, policy string) bool {
+	for _, attached := range auth.Policies {
+		if attached == policy {
+			return true
+		}
+	}
+	return false
+}
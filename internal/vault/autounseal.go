@@ -2,194 +2,120 @@ package vault
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"time"
 
 	vaultapi "github.com/hashicorp/vault/api"
-	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	"github.com/kubefirst/vault-handler/internal/metrics"
 	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
-func (conf *VaultConfiguration) AutoUnseal() (*vaultapi.InitResponse, error) {
-	vaultClient, err := vaultapi.NewClient(&conf.Config)
+// UnsealRaftLeader initializes and unseals a vault leader when using raft for ha and storage
+func (conf *VaultConfiguration) UnsealRaftLeader(clientset kubernetes.Interface, restConfig *rest.Config) error {
+	keyStore, err := conf.keyStore(clientset)
 	if err != nil {
-		return &vaultapi.InitResponse{}, err
+		return err
 	}
-	vaultClient.CloneConfig().ConfigureTLS(&vaultapi.TLSConfig{
-		Insecure: true,
-	})
-	log.Infof("created vault client, initializing vault with auto unseal")
-
-	initResponse, err := vaultClient.Sys().Init(&vaultapi.InitRequest{
-		RecoveryShares:    RecoveryShares,
-		RecoveryThreshold: RecoveryThreshold,
-		SecretShares:      SecretShares,
-		SecretThreshold:   SecretThreshold,
-	})
+
+	cluster, err := conf.DiscoverRaftCluster(clientset)
 	if err != nil {
-		return &vaultapi.InitResponse{}, err
+		return err
 	}
-	log.Infof("vault initialization complete")
-
-	return initResponse, nil
-}
+	node := cluster.Leader()
 
-// UnsealRaftLeader initializes and unseals a vault leader when using raft for ha and storage
-func (conf *VaultConfiguration) UnsealRaftLeader(clientset *kubernetes.Clientset, restConfig *rest.Config) error {
-	node := "vault-0"
-
-	pod, err := kubernetesinternal.ReturnPodObject(clientset, "statefulset.kubernetes.io/pod-name", node, "vault", 60)
+	pod, err := conf.nodePod(context.Background(), clientset, cluster.Namespace, node)
 	if err != nil {
 		return err
 	}
+	logger := log.WithFields(podFields(pod, "init"))
 
 	// Vault api client
-	vaultClient, err := vaultapi.NewClient(&vaultapi.Config{
-		Address: fmt.Sprintf("http://%s:8200", pod.Status.PodIP),
-	})
+	vaultClient, err := conf.newVaultOperationsForPod(clientset, pod)
 	if err != nil {
 		return err
 	}
 
-	vaultClient.CloneConfig().ConfigureTLS(&vaultapi.TLSConfig{
-		Insecure: true,
-	})
+	return conf.initOrUnsealNode(vaultClient, keyStore, pod, node, logger)
+}
 
+// initOrUnsealNode initializes a node and writes its initialization data to
+// keyStore, or unseals it with the shares held in keyStore when it is already
+// initialized
+func (conf *VaultConfiguration) initOrUnsealNode(vaultClient VaultOperations, keyStore KeyStore, pod *v1.Pod, node string, logger *log.Entry) error {
 	// Determine vault health
-	health, err := vaultClient.Sys().Health()
+	health, err := nodeHealth(vaultClient, node)
 	if err != nil {
 		return err
 	}
 
 	switch health.Initialized {
 	case false:
-		log.Infof("initializing vault raft leader")
-
-		initResponse, err := vaultClient.Sys().Init(&vaultapi.InitRequest{
-			SecretShares:    SecretShares,
-			SecretThreshold: SecretThreshold,
-		})
-		if err != nil {
-			return err
+		initRequest := &vaultapi.InitRequest{
+			SecretShares:    conf.SecretShares,
+			SecretThreshold: conf.SecretThreshold,
 		}
-
-		// Write secret containing init data
-		dataToWrite := make(map[string][]byte)
-		dataToWrite["root-token"] = []byte(initResponse.RootToken)
-		for i, value := range initResponse.Keys {
-			dataToWrite[fmt.Sprintf("root-unseal-key-%v", i+1)] = []byte(value)
+		if err := ensureKeyStoreEmpty(keyStore); err != nil {
+			return err
 		}
-		secret := v1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      VaultSecretName,
-				Namespace: VaultNamespace,
-			},
-			Data: dataToWrite,
+		if conf.plan("initialize %s with %v secret shares and a threshold of %v", node, initRequest.SecretShares, initRequest.SecretThreshold) {
+			conf.plan("write initialization data to %s", keyStore)
+			return conf.unsealNode(vaultClient, pod, node, nil, initRequest.SecretThreshold)
 		}
+		logger.Infof("initializing %s", node)
 
-		log.Infof("creating secret %s containing vault initialization data", VaultSecretName)
-		err = kubernetesinternal.CreateSecretV2(clientset, &secret)
+		initResponse, err := vaultClient.Init(initRequest)
 		if err != nil {
-			panic(err)
+			conf.recordEvent(pod, v1.EventTypeWarning, EventReasonInitFailed, "Error initializing vault: %s", err)
+			return err
 		}
-		time.Sleep(time.Second * 3)
-
-		// Unseal raft leader
-		sealStatusTracking := 0
-		for i, shard := range initResponse.Keys {
-			if i < SecretThreshold {
-				log.Infof("passing unseal shard %v to %s", i+1, node)
-				deadline := time.Now().Add(60 * time.Second)
-				ctx, cancel := context.WithDeadline(context.Background(), deadline)
-				defer cancel()
-				// Try 5 times to pass unseal shard
-				for i := 0; i < 5; i++ {
-					_, err := vaultClient.Sys().UnsealWithContext(ctx, shard)
-					if err != nil {
-						if errors.Is(err, context.DeadlineExceeded) {
-							continue
-						}
-					}
-					if i == 5 {
-						return fmt.Errorf("error passing unseal shard %v to %s: %s", i+1, node, err)
-					}
-				}
-				// Wait for key acceptance
-				for i := 0; i < 10; i++ {
-					sealStatus, err := vaultClient.Sys().SealStatus()
-					if err != nil {
-						return fmt.Errorf("error retrieving health of %s: %s", node, err)
-					}
-					if sealStatus.Progress > sealStatusTracking || !sealStatus.Sealed {
-						log.Infof("shard accepted")
-						sealStatusTracking += 1
-						break
-					}
-					log.Infof("waiting for node %s to accept unseal shard", node)
-					time.Sleep(time.Second * 6)
-				}
+
+		// Persist init data
+		initData := newInitData(initRequest, initResponse)
+		initData.describeCluster(vaultClient)
+		if err := keyStore.Save(initData); err != nil {
+			// Vault can't be initialized again, so the data is printed rather than lost
+			conf.recordEvent(pod, v1.EventTypeWarning, EventReasonInitFailed, "Vault was initialized but its initialization data could not be written to %s: %s", keyStore, err)
+			if writeErr := writeInitJSON(initData); writeErr != nil {
+				return fmt.Errorf("vault was initialized but its initialization data could not be persisted to %s: %s, or printed: %s", keyStore, err, writeErr)
 			}
+			return fmt.Errorf("vault was initialized but its initialization data could not be persisted to %s, it was printed to stdout instead: %s", keyStore, err)
+		}
+		conf.recordEvent(pod, v1.EventTypeNormal, EventReasonInitialized, "Initialized with %v secret shares and a threshold of %v, initialization data written to %s", initRequest.SecretShares, initRequest.SecretThreshold, keyStore)
+
+		// Unseal with the shares just issued
+		if err := conf.unsealNode(vaultClient, pod, node, initResponse.Keys, initRequest.SecretThreshold); err != nil {
+			return err
 		}
 	case true:
-		log.Infof("%s is already initialized", "vault-0")
+		logger.Infof("%s is already initialized", node)
 
 		// Determine vault health
-		health, err = vaultClient.Sys().Health()
+		health, err = nodeHealth(vaultClient, node)
 		if err != nil {
 			return err
 		}
 
 		switch health.Sealed {
 		case true:
-			existingInitResponse, err := parseExistingVaultInitSecret(clientset)
+			existingInitResponse, err := keyStore.Load()
+			if err != nil {
+				return err
+			}
+			unsealKeys, err := conf.unsealKeys(existingInitResponse)
 			if err != nil {
 				return err
 			}
 
-			// Unseal raft leader
-			sealStatusTracking := 0
-			node := "vault-0"
-			for i, shard := range existingInitResponse.Keys {
-				if i < SecretThreshold {
-					log.Infof("passing unseal shard %v to %s", i+1, node)
-					deadline := time.Now().Add(60 * time.Second)
-					ctx, cancel := context.WithDeadline(context.Background(), deadline)
-					defer cancel()
-					// Try 5 times to pass unseal shard
-					for i := 0; i < 5; i++ {
-						_, err := vaultClient.Sys().UnsealWithContext(ctx, shard)
-						if err != nil {
-							if errors.Is(err, context.DeadlineExceeded) {
-								continue
-							}
-						}
-						if i == 5 {
-							return fmt.Errorf("error passing unseal shard %v to %s: %s", i+1, node, err)
-						}
-					}
-					// Wait for key acceptance
-					for i := 0; i < 10; i++ {
-						sealStatus, err := vaultClient.Sys().SealStatus()
-						if err != nil {
-							return fmt.Errorf("error retrieving health of %s: %s", node, err)
-						}
-						if sealStatus.Progress > sealStatusTracking || !sealStatus.Sealed {
-							log.Infof("shard accepted")
-							sealStatusTracking += 1
-							break
-						}
-						log.Infof("waiting for node %s to accept unseal shard", node)
-						time.Sleep(time.Second * 6)
-					}
-				}
+			// Unseal with the stored shares
+			if err := conf.unsealNode(vaultClient, pod, node, unsealKeys, existingInitResponse.SecretThreshold); err != nil {
+				return err
 			}
+			conf.discardKeyStoreBackup(keyStore, true)
 		case false:
-			log.Infof("%s is already unsealed", "vault-0")
+			logger.WithField("phase", "unseal").Infof("%s is already unsealed", node)
 		}
 	}
 
@@ -197,109 +123,196 @@ func (conf *VaultConfiguration) UnsealRaftLeader(clientset *kubernetes.Clientset
 }
 
 // UnsealRaftFollowers initializes, unseals, and joins raft followers when using raft for ha and storage
-func (conf *VaultConfiguration) UnsealRaftFollowers(clientset *kubernetes.Clientset, restConfig *rest.Config) error {
-	// With the current iteration of the Vault helm chart, we create 3 nodes
-	// vault-0 is unsealed as leader, vault-1 and vault-2 are unsealed here
-	raftNodes := []string{"vault-1", "vault-2"}
-	existingInitResponse, err := parseExistingVaultInitSecret(clientset)
+func (conf *VaultConfiguration) UnsealRaftFollowers(clientset kubernetes.Interface, restConfig *rest.Config) error {
+	// The first StatefulSet replica is unsealed as leader, every other
+	// replica is joined and unsealed here
+	cluster, err := conf.DiscoverRaftCluster(clientset)
 	if err != nil {
 		return err
 	}
+	raftNodes := cluster.Followers()
+	if len(raftNodes) == 0 {
+		log.Infof("StatefulSet %s has no raft followers", cluster.StatefulSetName)
+		return nil
+	}
 
-	for _, node := range raftNodes {
-		pod, err := kubernetesinternal.ReturnPodObject(clientset, "statefulset.kubernetes.io/pod-name", node, "vault", 60)
+	keyStore, err := conf.keyStore(clientset)
+	if err != nil {
+		return err
+	}
+	existingInitResponse, err := keyStore.Load()
+	if err != nil {
+		if !conf.DryRun {
+			return err
+		}
+		// The plan for the leader initializes vault before followers are joined
+		log.Warnf("planning followers without initialization data from %s: %s", keyStore, err)
+		existingInitResponse = &InitData{SecretShares: conf.SecretShares, SecretThreshold: conf.SecretThreshold}
+	}
+
+	return conf.joinRaftFollowers(clientset, cluster, existingInitResponse)
+}
+
+// joinRaftFollowers joins every raft follower to the leader and unseals it -
+// followers of an auto unsealed vault are unsealed by their seal once joined,
+// so no unseal keys are sent to them
+func (conf *VaultConfiguration) joinRaftFollowers(clientset kubernetes.Interface, cluster *RaftCluster, data *InitData) error {
+	var unsealKeys []string
+	if !data.autoUnsealed() {
+		keys, err := conf.unsealKeys(data)
 		if err != nil {
 			return err
 		}
+		unsealKeys = keys
+	}
+
+	leaderClient, err := conf.raftLeaderClient(clientset, cluster, data)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range cluster.Followers() {
+		pod, err := conf.nodePod(context.Background(), clientset, cluster.Namespace, node)
+		if err != nil {
+			return err
+		}
+		logger := log.WithFields(podFields(pod, "join"))
 
 		// Vault api client
-		vaultClient, err := vaultapi.NewClient(&vaultapi.Config{
-			Address: fmt.Sprintf("http://%s:8200", pod.Status.PodIP),
-		})
+		vaultClient, err := conf.newVaultOperationsForPod(clientset, pod)
 		if err != nil {
 			return err
 		}
-		vaultClient.CloneConfig().ConfigureTLS(&vaultapi.TLSConfig{
-			Insecure: true,
-		})
-		log.Infof("created vault client for %s", node)
+		logger.Infof("created vault client for %s", node)
 
 		// Determine vault health
-		health, err := vaultClient.Sys().Health()
+		health, err := nodeHealth(vaultClient, node)
 		if err != nil {
 			return err
 		}
 
+		var joinStarted time.Time
 		switch health.Initialized {
 		case false:
 			// Join to raft cluster
-			log.Infof("joining raft follower %s to vault cluster", node)
-			_, err = vaultClient.Sys().RaftJoin(&vaultapi.RaftJoinRequest{
-				//AutoJoin:         "",
-				//AutoJoinScheme:   "",
-				//AutoJoinPort:     0,
-				LeaderAPIAddr: fmt.Sprintf("%s:8200", vaultRaftPrimaryAddress),
-				// LeaderCACert:     "",
-				// LeaderClientCert: "",
-				// LeaderClientKey:  "",
-				Retry: true,
-			})
+			raftJoinRequest, err := conf.raftJoinRequest(clientset, cluster)
 			if err != nil {
 				return err
 			}
-			time.Sleep(time.Second * 5)
+			if !conf.plan("join %s to raft leader %s at %s", node, cluster.Leader(), raftJoinRequest.LeaderAPIAddr) {
+				logger.Infof("joining raft follower %s to vault cluster", node)
+				joinStarted = time.Now()
+				_, err = vaultClient.RaftJoin(raftJoinRequest)
+				if err != nil {
+					conf.recordEvent(pod, v1.EventTypeWarning, EventReasonRaftJoinFailed, "Error joining raft leader %s at %s: %s", cluster.Leader(), raftJoinRequest.LeaderAPIAddr, err)
+					return err
+				}
+			}
 		case true:
-			log.Infof("raft follower %s is already initialized", node)
+			logger.Infof("raft follower %s is already initialized", node)
 		}
 
 		// Determine vault health
-		health, err = vaultClient.Sys().Health()
+		health, err = nodeHealth(vaultClient, node)
 		if err != nil {
 			return err
 		}
 
-		switch health.Sealed {
-		case true:
+		switch {
+		case health.Sealed && data.autoUnsealed():
+			if conf.plan("wait for %s to be unsealed by its seal", node) {
+				break
+			}
+			if err := conf.waitForAutoUnseal(vaultClient, node); err != nil {
+				conf.recordEvent(pod, v1.EventTypeWarning, EventReasonUnsealFailed, "%s", err)
+				return err
+			}
+			conf.recordEvent(pod, v1.EventTypeNormal, EventReasonUnsealed, "%s was unsealed by its seal", node)
+		case health.Sealed:
 			// Unseal raft followers
-			sealStatusTracking := 0
-			for i, shard := range existingInitResponse.Keys {
-				if i < SecretThreshold {
-					log.Infof("passing unseal shard %v to %s", i+1, node)
-					deadline := time.Now().Add(60 * time.Second)
-					ctx, cancel := context.WithDeadline(context.Background(), deadline)
-					defer cancel()
-					// Try 5 times to pass unseal shard
-					for i := 0; i < 5; i++ {
-						_, err := vaultClient.Sys().UnsealWithContext(ctx, shard)
-						if err != nil {
-							if errors.Is(err, context.DeadlineExceeded) {
-								continue
-							}
-						}
-						if i == 5 {
-							return fmt.Errorf("error passing unseal shard %v to %s: %s", i+1, node, err)
-						}
-					}
-					// Wait for key acceptance
-					for i := 0; i < 10; i++ {
-						sealStatus, err := vaultClient.Sys().SealStatus()
-						if err != nil {
-							return fmt.Errorf("error retrieving health of %s: %s", node, err)
-						}
-						if sealStatus.Progress > sealStatusTracking || !sealStatus.Sealed {
-							log.Infof("shard accepted")
-							sealStatusTracking += 1
-							break
-						}
-						log.Infof("waiting for node %s to accept unseal shard", node)
-						time.Sleep(time.Second * 6)
-					}
-				}
+			if err := conf.unsealNode(vaultClient, pod, node, unsealKeys, data.SecretThreshold); err != nil {
+				return err
 			}
-		case false:
-			log.Infof("raft follower %s is already unsealed", node)
+		default:
+			logger.WithField("phase", "unseal").Infof("raft follower %s is already unsealed", node)
+		}
+
+		// Confirm that the follower became a raft peer rather than trusting
+		// that an unsealed follower joined
+		if leaderClient != nil && !conf.DryRun {
+			if err := conf.waitForRaftVoters(leaderClient, []string{node}); err != nil {
+				conf.recordEvent(pod, v1.EventTypeWarning, EventReasonRaftJoinFailed, "%s", err)
+				return err
+			}
+		}
+		if !joinStarted.IsZero() {
+			metrics.ObserveRaftJoin(node, joinStarted)
+			conf.recordEvent(pod, v1.EventTypeNormal, EventReasonRaftJoined, "Joined raft leader %s", cluster.Leader())
 		}
 	}
 
+	if leaderClient != nil && !conf.DryRun {
+		return conf.waitForRaftVoters(leaderClient, cluster.Nodes)
+	}
 	return nil
 }
+
+// waitForAutoUnseal waits for an auto unsealed node to be unsealed by its seal
+func (conf *VaultConfiguration) waitForAutoUnseal(vaultClient VaultOperations, node string) error {
+	logger := log.WithFields(log.Fields{"node": node, "phase": "unseal"})
+	deadline := time.Now().Add(conf.RaftJoinTimeout)
+	for {
+		health, err := nodeHealth(vaultClient, node)
+		if err == nil && health.Initialized && !health.Sealed {
+			logger.Infof("%s was unsealed by its seal", node)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("timed out after %s waiting for %s to auto unseal: %s", conf.RaftJoinTimeout, node, err)
+			}
+			return fmt.Errorf("timed out after %s waiting for %s to auto unseal, check its seal configuration", conf.RaftJoinTimeout, node)
+		}
+
+		if err != nil {
+			logger.Warnf("error reading health of %s, retrying: %s", node, err)
+		} else {
+			logger.Infof("waiting for %s to auto unseal", node)
+		}
+		time.Sleep(raftCheckInterval)
+	}
+}
+
+// raftLeaderClient returns an authenticated client for the raft leader used to
+// verify raft membership, or nil when verification is skipped
+//
+// Verification fails without a token unless it was skipped explicitly, since
+// a follower that unsealed isn't necessarily a raft voter
+func (conf *VaultConfiguration) raftLeaderClient(clientset kubernetes.Interface, cluster *RaftCluster, data *InitData) (*vaultapi.Client, error) {
+	logger := log.WithField("phase", "verify")
+	if conf.SkipRaftVerification {
+		logger.Warn("raft membership of followers will not be verified")
+		return nil, nil
+	}
+	token, err := conf.token(data)
+	switch {
+	case err != nil && conf.DryRun:
+		logger.Warnf("raft membership of followers can't be verified: %s", err)
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("raft membership of followers can't be verified: %s - supply a token, such as an admin token once the root token was revoked, or pass --skip-raft-verification", err)
+	}
+
+	pod, err := conf.nodePod(context.Background(), clientset, cluster.Namespace, cluster.Leader())
+	if err != nil {
+		return nil, err
+	}
+	leaderClient, err := conf.newVaultClientForPod(clientset, pod)
+	if err != nil {
+		return nil, err
+	}
+	leaderClient.SetToken(token)
+
+	return leaderClient, nil
+}
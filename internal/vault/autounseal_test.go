@@ -0,0 +1,242 @@
+package vault
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// raftStub maps the nodes a leader vaultStub reports as raft peers to whether
+// they are voters, and non-voters are promoted once raftReads of the raft
+// configuration exceed promoteAfter
+type raftStub struct {
+	raftServers  map[string]bool
+	raftReads    int
+	promoteAfter int
+}
+
+func init() {
+	stubRoutes["/v1/sys/storage/raft/configuration"] = (*vaultStub).serveRaftConfiguration
+	stubRoutes["/v1/sys/storage/raft/autopilot/state"] = (*vaultStub).serveAutopilotState
+}
+
+// serveRaftConfiguration reports the raft peers of a leader
+func (stub *vaultStub) serveRaftConfiguration(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	stub.raftReads++
+	var servers []map[string]interface{}
+	for node := range stub.raftServers {
+		servers = append(servers, map[string]interface{}{
+			"node_id": node,
+			"address": node + ".vault-internal:8201",
+			"voter":   stub.raftVoter(node),
+		})
+	}
+	writeStubJSON(w, map[string]interface{}{"data": map[string]interface{}{"config": map[string]interface{}{"servers": servers}}})
+}
+
+// serveAutopilotState reports the autopilot status of the raft peers of a leader
+func (stub *vaultStub) serveAutopilotState(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	servers := make(map[string]interface{})
+	for node := range stub.raftServers {
+		status := "non-voter"
+		if stub.raftVoter(node) {
+			status = "voter"
+		}
+		servers[node] = map[string]interface{}{"id": node, "healthy": true, "status": status}
+	}
+	writeStubJSON(w, map[string]interface{}{"data": map[string]interface{}{"healthy": true, "servers": servers}})
+}
+
+// raftVoter returns whether the leader reports a raft peer as a voter
+func (stub *vaultStub) raftVoter(node string) bool {
+	return stub.raftServers[node] || stub.raftReads > stub.promoteAfter
+}
+
+func TestUnsealRaftLeader(t *testing.T) {
+	keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+
+	tests := []struct {
+		name       string
+		leader     *vaultStub
+		stored     bool
+		wantInits  int
+		wantShares int
+	}{
+		{
+			name:       "An uninitialized leader should be initialized, persisted and unsealed",
+			leader:     &vaultStub{sealed: true},
+			wantInits:  1,
+			wantShares: 2,
+		},
+		{
+			name:       "A sealed leader should be unsealed with the stored keys",
+			leader:     &vaultStub{initialized: true, sealed: true, keys: keys, threshold: 2},
+			stored:     true,
+			wantShares: 2,
+		},
+		{
+			name:   "An unsealed leader should be left untouched",
+			leader: &vaultStub{initialized: true, keys: keys, threshold: 2},
+			stored: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset, conf := newTestCluster(t, tt.leader)
+			if tt.stored {
+				storeInitData(t, clientset, conf, keys, 2)
+			}
+
+			if err := conf.UnsealRaftLeader(clientset, nil); err != nil {
+				t.Fatalf("UnsealRaftLeader() error = %v", err)
+			}
+
+			if !tt.leader.initialized || tt.leader.sealed {
+				t.Errorf("leader initialized = %v, sealed = %v, want initialized and unsealed", tt.leader.initialized, tt.leader.sealed)
+			}
+			if tt.leader.inits != tt.wantInits {
+				t.Errorf("leader received %v init requests, want %v", tt.leader.inits, tt.wantInits)
+			}
+			if tt.leader.shares != tt.wantShares {
+				t.Errorf("leader received %v unseal shares, want %v", tt.leader.shares, tt.wantShares)
+			}
+
+			keyStore, _ := conf.keyStore(clientset)
+			data, err := keyStore.Load()
+			if err != nil {
+				t.Fatalf("initialization data wasn't persisted: %v", err)
+			}
+			if !reflect.DeepEqual(data.Keys, tt.leader.keys) {
+				t.Errorf("persisted keys = %v, want %v", data.Keys, tt.leader.keys)
+			}
+		})
+	}
+}
+
+func TestUnsealRaftFollowers(t *testing.T) {
+	keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+
+	tests := []struct {
+		name        string
+		raftServers map[string]bool
+		wantErr     string
+	}{
+		{
+			name:        "Followers should be verified once the leader reports them as voters",
+			raftServers: map[string]bool{"vault-0": true, "vault-1": false, "vault-2": true},
+		},
+		{
+			name:        "A follower the leader never reports as a raft peer should time out",
+			raftServers: map[string]bool{"vault-0": true, "vault-2": true},
+			wantErr:     "vault-1: not a raft peer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := &vaultStub{initialized: true, keys: keys, threshold: 2, raftStub: raftStub{raftServers: tt.raftServers, promoteAfter: 1}}
+			joining := &vaultStub{sealed: true, keys: keys, threshold: 2}
+			joined := &vaultStub{initialized: true, keys: keys, threshold: 2}
+
+			clientset, conf := newTestCluster(t, leader, joining, joined)
+			storeInitData(t, clientset, conf, keys, 2)
+			storeRootToken(t, clientset, conf, "hvs.stubroottoken")
+
+			err := conf.UnsealRaftFollowers(clientset, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("UnsealRaftFollowers() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UnsealRaftFollowers() error = %v", err)
+			}
+			if leader.raftReads < 2 {
+				t.Errorf("leader raft configuration was read %v times, want the non-voter to be polled until promoted", leader.raftReads)
+			}
+
+			wantLeader := "http://vault-0.vault-internal:8200"
+			if joining.joinedLeader != wantLeader {
+				t.Errorf("uninitialized follower joined %q, want %q", joining.joinedLeader, wantLeader)
+			}
+			if joining.sealed || joining.shares != 2 {
+				t.Errorf("uninitialized follower sealed = %v after %v shares, want unsealed after 2", joining.sealed, joining.shares)
+			}
+			if joined.joinedLeader != "" || joined.shares != 0 {
+				t.Errorf("unsealed follower was joined to %q and sent %v shares, want it left untouched", joined.joinedLeader, joined.shares)
+			}
+			if leader.shares != 0 || leader.joinedLeader != "" {
+				t.Errorf("leader was sent %v shares and joined to %q, want it left untouched", leader.shares, leader.joinedLeader)
+			}
+		})
+	}
+}
+
+func TestUnsealRaftFollowersWithoutToken(t *testing.T) {
+	keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+	leader := &vaultStub{initialized: true, keys: keys, threshold: 2}
+	follower := &vaultStub{initialized: true, keys: keys, threshold: 2}
+
+	clientset, conf := newTestCluster(t, leader, follower)
+	storeInitData(t, clientset, conf, keys, 2)
+
+	err := conf.UnsealRaftFollowers(clientset, nil)
+	if err == nil || !strings.Contains(err.Error(), "--skip-raft-verification") {
+		t.Fatalf("UnsealRaftFollowers() error = %v, want verification to fail without a token", err)
+	}
+
+	conf.SkipRaftVerification = true
+	if err := conf.UnsealRaftFollowers(clientset, nil); err != nil {
+		t.Fatalf("UnsealRaftFollowers() with verification skipped error = %v", err)
+	}
+}
+
+func TestUnsealRaftLeaderWithExistingKeyStore(t *testing.T) {
+	appFS := afero.NewMemMapFs()
+	originalFS := fs
+	fs = appFS
+	t.Cleanup(func() { fs = originalFS })
+
+	tests := []struct {
+		name     string
+		keyStore KeyStoreOptions
+		seed     func(t *testing.T, clientset *fake.Clientset, conf *VaultConfiguration)
+	}{
+		{
+			name: "An existing Secret should stop an uninitialized leader from being initialized",
+			seed: func(t *testing.T, clientset *fake.Clientset, conf *VaultConfiguration) {
+				storeInitData(t, clientset, conf, []string{"d4d4d4d4"}, 1)
+			},
+		},
+		{
+			name:     "An existing file should stop an uninitialized leader from being initialized",
+			keyStore: KeyStoreOptions{Type: KeyStoreFile, Path: "/vault-init.json"},
+			seed: func(t *testing.T, clientset *fake.Clientset, conf *VaultConfiguration) {
+				if err := afero.WriteFile(appFS, "/vault-init.json", []byte("{}"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := &vaultStub{sealed: true}
+			clientset, conf := newTestCluster(t, leader)
+			if tt.keyStore.Type != "" {
+				conf.KeyStore = tt.keyStore
+			}
+			tt.seed(t, clientset, conf)
+
+			if err := conf.UnsealRaftLeader(clientset, nil); err == nil {
+				t.Fatal("UnsealRaftLeader() succeeded, want an error for the existing key store")
+			}
+			if leader.inits != 0 {
+				t.Errorf("leader received %v init requests, want none", leader.inits)
+			}
+		})
+	}
+}
@@ -1,15 +1,117 @@
 package vault
 
 import (
+	"context"
+	"fmt"
+
 	vaultapi "github.com/hashicorp/vault/api"
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 var Conf VaultConfiguration = VaultConfiguration{
-	Config: NewVault(),
+	Config:          NewVault(),
+	Namespace:       VaultNamespace,
+	StatefulSetName: VaultStatefulSetName,
+	KeyStore: KeyStoreOptions{
+		Type:            KeyStoreKubernetes,
+		SecretName:      VaultSecretName,
+		RootTokenKey:    VaultRootTokenKey,
+		UnsealKeyPrefix: VaultUnsealKeyPrefix,
+	},
+	Retry: RetryPolicy{
+		Timeout:        UnsealTimeout,
+		MaxAttempts:    UnsealMaxAttempts,
+		InitialBackoff: UnsealInitialBackoff,
+		MaxBackoff:     UnsealMaxBackoff,
+	},
+	RaftJoinTimeout: RaftJoinTimeout,
+	PodCondition:    kubernetesinternal.PodRunning,
+	PodWaitTimeout:  PodWaitTimeout,
+	Port:            VaultPort,
+	SecretShares:    SecretShares,
+	SecretThreshold: SecretThreshold,
 }
 
-func NewVault() vaultapi.Config {
+func NewVault() *vaultapi.Config {
 	config := vaultapi.DefaultConfig()
 
-	return *config
+	return config
+}
+
+// nodePod waits for the Pod of a vault node to meet the configured condition,
+// which defaults to running
+func (conf *VaultConfiguration) nodePod(ctx context.Context, clientset kubernetes.Interface, namespace string, node string) (*v1.Pod, error) {
+	if conf.PodWaitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.PodWaitTimeout)
+		defer cancel()
+	}
+	condition := conf.PodCondition
+	if condition == "" {
+		condition = kubernetesinternal.PodRunning
+	}
+	return kubernetesinternal.WaitForPod(ctx, clientset, namespace, fmt.Sprintf("%s=%s", statefulSetPodNameLabel, node), condition)
+}
+
+// newVaultClientForPod returns a vault api client that targets the supplied Pod
+func (conf *VaultConfiguration) newVaultClientForPod(clientset kubernetes.Interface, pod *v1.Pod) (*vaultapi.Client, error) {
+	address, err := conf.podAddress(clientset, pod)
+	if err != nil {
+		return nil, err
+	}
+	return conf.newVaultClient(clientset, address)
+}
+
+// newVaultOperationsForPod returns the vault operations used to initialize,
+// unseal and join the supplied Pod, created by the configured factory
+func (conf *VaultConfiguration) newVaultOperationsForPod(clientset kubernetes.Interface, pod *v1.Pod) (VaultOperations, error) {
+	return conf.newAuthenticatedOperationsForPod(clientset, pod, "")
+}
+
+// newAuthenticatedOperationsForPod returns the vault operations of the
+// supplied Pod authenticated with token, for calls such as seal that need one
+func (conf *VaultConfiguration) newAuthenticatedOperationsForPod(clientset kubernetes.Interface, pod *v1.Pod, token string) (VaultOperations, error) {
+	address, err := conf.podAddress(clientset, pod)
+	if err != nil {
+		return nil, err
+	}
+	config, err := conf.vaultAPIConfig(clientset, address)
+	if err != nil {
+		return nil, err
+	}
+
+	newOperations := conf.NewOperations
+	if newOperations == nil {
+		newOperations = NewVaultOperations
+	}
+	return newOperations(config, token)
+}
+
+// podAddress returns the vault api address of the supplied Pod
+//
+// When port-forwarding is enabled the Pod is reached through a local port
+// instead of its Pod IP
+func (conf *VaultConfiguration) podAddress(clientset kubernetes.Interface, pod *v1.Pod) (string, error) {
+	if conf.portForwardEnabled() {
+		localPort, err := conf.forwardedPort(clientset, pod)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s://127.0.0.1:%v", conf.scheme(), localPort), nil
+	}
+	return fmt.Sprintf("%s://%s:%v", conf.scheme(), pod.Status.PodIP, conf.Port), nil
+}
+
+// podFields returns the log fields identifying a vault Pod and the phase of
+// work done against it
+func podFields(pod *v1.Pod, phase string) log.Fields {
+	return log.Fields{
+		"node":      pod.Name,
+		"pod":       pod.Name,
+		"namespace": pod.Namespace,
+		"phase":     phase,
+	}
 }
@@ -0,0 +1,83 @@
+package vault
+
+import (
+	"fmt"
+
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RaftCluster describes the vault raft members backing a StatefulSet
+type RaftCluster struct {
+	Namespace       string
+	StatefulSetName string
+	ServiceName     string
+	// Selector holds the label selector matching the StatefulSet Pods
+	Selector string
+	// Nodes holds the Pod names of every replica in ordinal order
+	Nodes []string
+}
+
+// DiscoverRaftCluster reads the vault StatefulSet and enumerates its replicas
+func (conf *VaultConfiguration) DiscoverRaftCluster(clientset kubernetes.Interface) (*RaftCluster, error) {
+	return conf.discoverRaftCluster(clientset, conf.StatefulSetName)
+}
+
+// discoverRaftCluster reads the named StatefulSet in the vault Namespace and
+// enumerates its replicas
+func (conf *VaultConfiguration) discoverRaftCluster(clientset kubernetes.Interface, statefulSetName string) (*RaftCluster, error) {
+	statefulSet, err := kubernetesinternal.ReturnStatefulSetObject(clientset, conf.Namespace, statefulSetName)
+	if err != nil {
+		return nil, err
+	}
+
+	// A StatefulSet without replicas set defaults to a single replica
+	replicas := 1
+	if statefulSet.Spec.Replicas != nil {
+		replicas = int(*statefulSet.Spec.Replicas)
+	}
+	if replicas < 1 {
+		return nil, fmt.Errorf("StatefulSet %s in Namespace %s has no replicas", statefulSetName, conf.Namespace)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(statefulSet.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing StatefulSet %s selector: %s", statefulSet.Name, err)
+	}
+
+	// StatefulSet Pods are always named <statefulset>-<ordinal>
+	nodes := make([]string, 0, replicas)
+	for i := 0; i < replicas; i++ {
+		nodes = append(nodes, fmt.Sprintf("%s-%v", statefulSet.Name, i))
+	}
+	log.Infof("discovered %v vault raft nodes in StatefulSet %s", replicas, statefulSet.Name)
+
+	return &RaftCluster{
+		Namespace:       statefulSet.Namespace,
+		StatefulSetName: statefulSet.Name,
+		ServiceName:     statefulSet.Spec.ServiceName,
+		Selector:        selector.String(),
+		Nodes:           nodes,
+	}, nil
+}
+
+// Leader returns the node that is initialized and unsealed as raft leader
+func (cluster *RaftCluster) Leader() string {
+	return cluster.Nodes[0]
+}
+
+// Followers returns the nodes that join the raft leader
+func (cluster *RaftCluster) Followers() []string {
+	return cluster.Nodes[1:]
+}
+
+// LeaderAPIAddress returns the address raft followers use to reach the leader
+// through the StatefulSet's governing Service
+func (cluster *RaftCluster) LeaderAPIAddress(scheme string, port int) string {
+	if cluster.ServiceName == "" {
+		return fmt.Sprintf("%s://%s:%v", scheme, cluster.Leader(), port)
+	}
+	return fmt.Sprintf("%s://%s.%s:%v", scheme, cluster.Leader(), cluster.ServiceName, port)
+}
@@ -1,19 +1,61 @@
 package vault
 
+import "time"
+
 const (
 	// Rate at which to check for Vault health
-	checkInterval int = 10
+	CheckInterval time.Duration = 10 * time.Second
+	// Maximum delay between failed unseal attempts against a node
+	MaxUnsealBackoff time.Duration = 5 * time.Minute
+	// Default retry policy for submitting a single unseal share
+	UnsealTimeout        time.Duration = 60 * time.Second
+	UnsealMaxAttempts    int           = 5
+	UnsealInitialBackoff time.Duration = time.Second
+	UnsealMaxBackoff     time.Duration = 30 * time.Second
+	// Default time a joined follower has to become a healthy raft voter
+	RaftJoinTimeout time.Duration = 2 * time.Minute
+	// Rate at which raft membership is checked
+	raftCheckInterval time.Duration = 2 * time.Second
 	// Vault API endpoints
 	vaultHealthEndpoint string = "/v1/sys/health"
 	vaultInitEndpoint   string = "/v1/sys/init"
 	vaultRaftEndpoint   string = "/sys/storage/raft"
 	vaultUnsealEndpoint string = "/v1/sys/unseal"
-	// For raft, vault-0 will always be primary referenced by its endpoint
-	vaultRaftPrimaryAddress string = "http://vault-0.vault-internal"
+	// Time given to recorded Kubernetes Events to be delivered before exiting
+	eventFlushDelay time.Duration = time.Second
+	// Default component reported as the source of Kubernetes Events
+	EventComponent string = "vault-handler"
+	// Reasons of Kubernetes Events recorded against vault Pods
+	EventReasonInitialized          string = "Initialized"
+	EventReasonInitFailed           string = "InitFailed"
+	EventReasonUnsealShareSubmitted string = "UnsealShareSubmitted"
+	EventReasonUnsealed             string = "Unsealed"
+	EventReasonUnsealFailed         string = "UnsealFailed"
+	EventReasonRaftJoined           string = "RaftJoined"
+	EventReasonRaftJoinFailed       string = "RaftJoinFailed"
+	EventReasonRekeyed              string = "Rekeyed"
+	EventReasonRekeyFailed          string = "RekeyFailed"
+	EventReasonRootTokenGenerated   string = "RootTokenGenerated"
+	EventReasonRootTokenFailed      string = "RootTokenFailed"
+	EventReasonRootTokenRevoked     string = "RootTokenRevoked"
+	EventReasonHardenFailed         string = "HardenFailed"
+	EventReasonSealed               string = "Sealed"
+	EventReasonSealFailed           string = "SealFailed"
+	// Default time a vault Pod has to meet the Pod condition waited for
+	PodWaitTimeout time.Duration = 60 * time.Second
+	// Label the StatefulSet controller sets to the name of each Pod
+	statefulSetPodNameLabel string = "statefulset.kubernetes.io/pod-name"
+	// Suffixes of the backup a rekey keeps of the previous initialization data
+	KeyStoreBackupSecretSuffix string = "-backup"
+	KeyStoreBackupFileSuffix   string = ".backup"
+	// Default port the vault API listens on
+	VaultPort int = 8200
 	// Name for the Secret that gets created that contains root auth data
 	VaultSecretName string = "vault-unseal-secret"
-	// Namespace that Vault runs in
+	// Default Namespace that Vault runs in
 	VaultNamespace string = "vault"
+	// Default name of the StatefulSet that runs Vault
+	VaultStatefulSetName string = "vault"
 	// number of recovery shares for Vault unseal
 	RecoveryShares int = 5
 	// number of recovery keys for Vault
@@ -22,4 +64,74 @@ const (
 	SecretShares = 5
 	// number of secret threshold Vault unseal
 	SecretThreshold = 3
+	// Supported destinations for vault initialization data
+	InitOutputKeyStore string = "key-store"
+	InitOutputStdout   string = "stdout"
+	// Defaults for transit auto unseal bootstrap
+	TransitMount           string        = "transit"
+	TransitKeyName         string        = "autounseal"
+	TransitPolicyName      string        = "autounseal"
+	TransitTokenPeriod     time.Duration = 24 * time.Hour
+	TransitTokenSecretName string        = "vault-transit-token"
+	TransitTokenSecretKey  string        = "token"
+	// Default StatefulSet and key store Secret of the core transit instance
+	TransitCoreStatefulSetName    string = "vault-transit"
+	TransitCoreKeyStoreSecretName string = "vault-transit-unseal-secret"
+	// Supported admin auth methods and defaults for hardening
+	AuthMethodUserpass   string        = "userpass"
+	AuthMethodKubernetes string        = "kubernetes"
+	AdminPolicyName      string        = "admin"
+	AdminName            string        = "admin"
+	AdminTokenTTL        time.Duration = time.Hour
+	AdminSecretName      string        = "vault-admin-credentials"
+	AdminSecretUserKey   string        = "username"
+	AdminSecretPassKey   string        = "password"
+	// Environment variable holding the password of the admin userpass user
+	AdminPasswordEnv string = "VAULT_HANDLER_ADMIN_PASSWORD"
+	// Length of generated admin passwords
+	AdminPasswordLength int = 32
+	// Default path the kubernetes auth method is bootstrapped at
+	KubernetesAuthMount string = "kubernetes"
+	// Address vault reaches the Kubernetes API server at from within the cluster
+	KubernetesHost string = "https://kubernetes.default.svc"
+	// Default time every node is retried until it reports sealed
+	SealTimeout time.Duration = 60 * time.Second
+	// Delay between attempts to seal a node that doesn't report sealed yet
+	sealRetryInterval time.Duration = time.Second
+	// Outcomes of sealing a node
+	SealResultSealed        string = "sealed"
+	SealResultAlreadySealed string = "already-sealed"
+	SealResultPlanned       string = "would-seal"
+	SealResultFailed        string = "failed"
+	// Supported formats for command output
+	OutputTable string = "table"
+	OutputJSON  string = "json"
+	OutputYAML  string = "yaml"
+	// Raft roles reported for a node
+	RaftRoleLeader        string = "leader"
+	RaftRoleFollower      string = "follower"
+	RaftRoleUninitialized string = "uninitialized"
+	RaftRoleUnknown       string = "unknown"
+	// Supported key stores for vault initialization data
+	KeyStoreKubernetes string = "kubernetes"
+	KeyStoreFile       string = "file"
+	KeyStoreAge        string = "age"
+	// Environment variable holding the passphrase for the age key store
+	KeyStorePassphraseEnv string = "VAULT_HANDLER_KEY_STORE_PASSPHRASE"
+	// Default Secret keys for the root token and unseal keys
+	VaultRootTokenKey    string = "root-token"
+	VaultUnsealKeyPrefix string = "root-unseal-key-"
+	// Secret keys holding TLS material
+	TLSSecretCAKey   string = "ca.crt"
+	TLSSecretCertKey string = "tls.crt"
+	TLSSecretKeyKey  string = "tls.key"
+	// Secret keys holding versioned initialization data
+	VaultInitDataKey        string = "init.json"
+	VaultInitDataVersionKey string = "init-data-version"
+	// Current version of the initialization data layout
+	InitDataVersion int = 2
+	// Secret keys holding custodian metadata for pgp encrypted shares in the original layout
+	VaultPGPEncryptedKey       string = "pgp-encrypted"
+	VaultCustodiansKey         string = "custodians"
+	VaultRootTokenCustodianKey string = "root-token-custodian"
 )
@@ -0,0 +1,58 @@
+package vault
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	v1 "k8s.io/api/core/v1"
+)
+
+// stdout receives the output meant for the operator rather than the logs,
+// replaced in tests
+var stdout io.Writer = os.Stdout
+
+// plan prints an action to stdout when running in dry-run mode, returning
+// true when the caller must skip it
+func (conf *VaultConfiguration) plan(format string, args ...interface{}) bool {
+	if !conf.DryRun {
+		return false
+	}
+	fmt.Fprintf(stdout, "would "+format+"\n", args...)
+	return true
+}
+
+// unsealNode passes unseal keys to a node, recording Events against its Pod,
+// or reports how many shares would be submitted in dry-run mode - threshold is
+// used when the node can't report its own, such as before it is initialized
+func (conf *VaultConfiguration) unsealNode(vaultClient VaultOperations, pod *v1.Pod, node string, unsealKeys []string, threshold int) error {
+	if !conf.DryRun {
+		submitted := 0
+		err := unsealNodeWithProgress(vaultClient, node, unsealKeys, &conf.Retry, func(share int, sealStatus *vaultapi.SealStatusResponse) {
+			submitted++
+			if sealStatus.Sealed {
+				conf.recordEvent(pod, v1.EventTypeNormal, EventReasonUnsealShareSubmitted, "Unseal share %v accepted, unseal progress is %v/%v", share, sealStatus.Progress, sealStatus.T)
+			} else {
+				conf.recordEvent(pod, v1.EventTypeNormal, EventReasonUnsealShareSubmitted, "Unseal share %v accepted", share)
+			}
+		})
+		if err != nil {
+			conf.recordEvent(pod, v1.EventTypeWarning, EventReasonUnsealFailed, "Error unsealing %s: %s", node, err)
+			return err
+		}
+		if submitted > 0 {
+			conf.recordEvent(pod, v1.EventTypeNormal, EventReasonUnsealed, "%s unsealed after %v unseal shares", node, submitted)
+		}
+		return nil
+	}
+
+	if sealStatus, err := vaultClient.SealStatus(); err == nil && sealStatus.T > 0 {
+		threshold = sealStatus.T
+	}
+	if threshold == 0 {
+		threshold = len(unsealKeys)
+	}
+	conf.plan("submit %v unseal shares to %s", threshold, node)
+	return nil
+}
@@ -0,0 +1,110 @@
+package vault
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDryRun(t *testing.T) {
+	keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+
+	tests := []struct {
+		name     string
+		node     *vaultStub
+		setup    func(t *testing.T, clientset *fake.Clientset, conf *VaultConfiguration)
+		run      func(clientset *fake.Clientset, conf *VaultConfiguration) error
+		wantPlan []string
+	}{
+		{
+			name: "Init should only plan initializing the leader and persisting its data",
+			node: &vaultStub{sealed: true},
+			run: func(clientset *fake.Clientset, conf *VaultConfiguration) error {
+				_, err := conf.InitRaftLeader(clientset, &VaultInitExecutionOptions{SecretShares: 3, SecretThreshold: 2, Output: InitOutputKeyStore})
+				return err
+			},
+			wantPlan: []string{"would initialize vault-0 with 3 secret shares", "would write initialization data to Secret vault-unseal-secret"},
+		},
+		{
+			name: "Unseal of an uninitialized leader should only plan init and unseal",
+			node: &vaultStub{sealed: true},
+			run: func(clientset *fake.Clientset, conf *VaultConfiguration) error {
+				return conf.UnsealRaftLeader(clientset, nil)
+			},
+			wantPlan: []string{"would initialize vault-0", "would submit 2 unseal shares to vault-0"},
+		},
+		{
+			name: "Unseal of a sealed leader should only plan submitting its shares",
+			node: &vaultStub{initialized: true, sealed: true, keys: keys, threshold: 2},
+			setup: func(t *testing.T, clientset *fake.Clientset, conf *VaultConfiguration) {
+				storeInitData(t, clientset, conf, keys, 2)
+			},
+			run: func(clientset *fake.Clientset, conf *VaultConfiguration) error {
+				return conf.UnsealRaftLeader(clientset, nil)
+			},
+			wantPlan: []string{"would submit 2 unseal shares to vault-0"},
+		},
+		{
+			name: "Transit bootstrap of an uninitialized core instance should only plan every step",
+			node: &vaultStub{sealed: true},
+			run: func(clientset *fake.Clientset, conf *VaultConfiguration) error {
+				return conf.BootstrapTransit(clientset, nil, transitOptions())
+			},
+			wantPlan: []string{"would initialize", "would enable transit engine at transit", "would create a periodic transit unseal token"},
+		},
+		{
+			name: "Transit bootstrap of an initialized core instance should only plan the missing steps",
+			node: &vaultStub{initialized: true, keys: keys, threshold: 2},
+			setup: func(t *testing.T, clientset *fake.Clientset, conf *VaultConfiguration) {
+				keyStore, err := conf.coreKeyStore(clientset, transitOptions())
+				if err != nil {
+					t.Fatal(err)
+				}
+				data := &InitData{Version: InitDataVersion, InitResponse: vaultapi.InitResponse{Keys: keys, RootToken: "hvs.stubroottoken"}, SecretShares: 3, SecretThreshold: 2}
+				if err := keyStore.Save(data); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run: func(clientset *fake.Clientset, conf *VaultConfiguration) error {
+				return conf.BootstrapTransit(clientset, nil, transitOptions())
+			},
+			wantPlan: []string{"would enable transit engine at transit", "would create transit key autounseal", "would write policy autounseal", "would create a periodic transit unseal token"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset, conf := newTestCluster(t, tt.node)
+			conf.Config = &vaultapi.Config{Address: "http://10.0.0.1:8200"}
+			if tt.setup != nil {
+				tt.setup(t, clientset, conf)
+			}
+			clientset.ClearActions()
+			conf.DryRun = true
+			var output bytes.Buffer
+			originalStdout := stdout
+			stdout = &output
+			t.Cleanup(func() { stdout = originalStdout })
+
+			if err := tt.run(clientset, conf); err != nil {
+				t.Fatalf("dry run error = %v", err)
+			}
+
+			if tt.node.inits != 0 || tt.node.shares != 0 || len(tt.node.writes) != 0 {
+				t.Errorf("vault received %v init requests, %v unseal shares and writes %+v, want none", tt.node.inits, tt.node.shares, tt.node.writes)
+			}
+			for _, action := range clientset.Actions() {
+				if action.GetVerb() != "get" && action.GetVerb() != "list" && action.GetVerb() != "watch" {
+					t.Errorf("Kubernetes received a %s of %s, want only reads", action.GetVerb(), action.GetResource().Resource)
+				}
+			}
+			for _, want := range tt.wantPlan {
+				if !strings.Contains(output.String(), want) {
+					t.Errorf("planned steps don't include %q:\n%s", want, output.String())
+				}
+			}
+		})
+	}
+}
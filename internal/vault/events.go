@@ -0,0 +1,79 @@
+package vault
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// eventRecorder records Kubernetes Events for the actions taken against vault Pods
+type eventRecorder struct {
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+	// recorded is set once an Event was recorded, so that Close only waits
+	// for delivery when there is something to deliver
+	recorded int32
+}
+
+// EnableEvents records Kubernetes Events against vault Pods and their
+// StatefulSet, reported by the supplied component - Events are flushed by
+// Close or when the handler exits fatally
+func (conf *VaultConfiguration) EnableEvents(clientset kubernetes.Interface, component string) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	conf.events = &eventRecorder{
+		broadcaster: broadcaster,
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component}),
+	}
+	log.RegisterExitHandler(conf.closeEvents)
+}
+
+// Close stops every port-forward and flushes recorded Events
+func (conf *VaultConfiguration) Close() {
+	conf.ClosePortForwards()
+	conf.closeEvents()
+}
+
+// closeEvents gives recorded Events time to be delivered, since the recorder
+// sends them asynchronously, then stops the broadcaster
+func (conf *VaultConfiguration) closeEvents() {
+	if conf.events == nil {
+		return
+	}
+	if atomic.LoadInt32(&conf.events.recorded) == 1 {
+		time.Sleep(eventFlushDelay)
+	}
+	conf.events.broadcaster.Shutdown()
+	conf.events = nil
+}
+
+// recordEvent records an Event against a vault Pod and the StatefulSet that
+// owns it - nothing is recorded in dry-run mode, or for an instance reached
+// by address rather than through its Pod
+func (conf *VaultConfiguration) recordEvent(pod *v1.Pod, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if conf.events == nil || conf.DryRun || pod == nil {
+		return
+	}
+	atomic.StoreInt32(&conf.events.recorded, 1)
+
+	conf.events.recorder.Eventf(pod, eventType, reason, messageFmt, args...)
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind != "StatefulSet" {
+			continue
+		}
+		statefulSet := &v1.ObjectReference{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			Namespace:  pod.Namespace,
+			UID:        owner.UID,
+		}
+		conf.events.recorder.Eventf(statefulSet, eventType, reason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,153 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// recordTestEvents records the Events of a configuration with a fake recorder
+func recordTestEvents(conf *VaultConfiguration) *record.FakeRecorder {
+	recorder := record.NewFakeRecorder(100)
+	conf.events = &eventRecorder{recorder: recorder}
+	return recorder
+}
+
+// recordedEvents returns the type and reason of every Event recorded so far
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			fields := strings.SplitN(event, " ", 3)
+			events = append(events, fields[0]+" "+fields[1])
+		default:
+			return events
+		}
+	}
+}
+
+func TestRecordEvent(t *testing.T) {
+	keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+
+	tests := []struct {
+		name    string
+		run     func(t *testing.T) (*VaultConfiguration, func() error)
+		wantErr bool
+		want    []string
+	}{
+		{
+			name: "Initializing and unsealing a leader should record each outcome",
+			run: func(t *testing.T) (*VaultConfiguration, func() error) {
+				clientset, conf := newTestCluster(t, &vaultStub{sealed: true})
+				return conf, func() error { return conf.UnsealRaftLeader(clientset, nil) }
+			},
+			want: []string{
+				"Normal " + EventReasonInitialized,
+				"Normal " + EventReasonUnsealShareSubmitted,
+				"Normal " + EventReasonUnsealShareSubmitted,
+				"Normal " + EventReasonUnsealed,
+			},
+		},
+		{
+			name: "Initialization data that can't be persisted should record a warning",
+			run: func(t *testing.T) (*VaultConfiguration, func() error) {
+				clientset, conf := newTestCluster(t, &vaultStub{sealed: true})
+				clientset.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("secrets is forbidden")
+				})
+				var output bytes.Buffer
+				originalStdout := stdout
+				stdout = &output
+				t.Cleanup(func() { stdout = originalStdout })
+				opts := &VaultInitExecutionOptions{SecretShares: 3, SecretThreshold: 2, Output: InitOutputKeyStore}
+				return conf, func() error {
+					_, err := conf.InitRaftLeader(clientset, opts)
+					return err
+				}
+			},
+			wantErr: true,
+			want:    []string{"Warning " + EventReasonInitFailed},
+		},
+		{
+			name: "Rejected unseal keys should record a warning",
+			run: func(t *testing.T) (*VaultConfiguration, func() error) {
+				clientset, conf := newTestCluster(t, &vaultStub{initialized: true, sealed: true, keys: keys, threshold: 2})
+				storeInitData(t, clientset, conf, []string{"d4d4d4d4", "e5e5e5e5", "f6f6f6f6"}, 2)
+				return conf, func() error { return conf.UnsealRaftLeader(clientset, nil) }
+			},
+			wantErr: true,
+			want:    []string{"Warning " + EventReasonUnsealFailed},
+		},
+		{
+			name: "Joining and unsealing a follower should record each outcome",
+			run: func(t *testing.T) (*VaultConfiguration, func() error) {
+				leader := &vaultStub{initialized: true, keys: keys, threshold: 2, raftStub: raftStub{raftServers: map[string]bool{"vault-0": true, "vault-1": true}}}
+				clientset, conf := newTestCluster(t, leader, &vaultStub{sealed: true, keys: keys, threshold: 2})
+				storeInitData(t, clientset, conf, keys, 2)
+				storeRootToken(t, clientset, conf, "hvs.stubroottoken")
+				return conf, func() error { return conf.UnsealRaftFollowers(clientset, nil) }
+			},
+			want: []string{
+				"Normal " + EventReasonUnsealShareSubmitted,
+				"Normal " + EventReasonUnsealShareSubmitted,
+				"Normal " + EventReasonUnsealed,
+				"Normal " + EventReasonRaftJoined,
+			},
+		},
+		{
+			name: "A follower the leader never reports as a raft peer should record a warning",
+			run: func(t *testing.T) (*VaultConfiguration, func() error) {
+				leader := &vaultStub{initialized: true, keys: keys, threshold: 2, raftStub: raftStub{raftServers: map[string]bool{"vault-0": true}}}
+				clientset, conf := newTestCluster(t, leader, &vaultStub{sealed: true, keys: keys, threshold: 2})
+				storeInitData(t, clientset, conf, keys, 2)
+				storeRootToken(t, clientset, conf, "hvs.stubroottoken")
+				return conf, func() error { return conf.UnsealRaftFollowers(clientset, nil) }
+			},
+			wantErr: true,
+			want: []string{
+				"Normal " + EventReasonUnsealShareSubmitted,
+				"Normal " + EventReasonUnsealShareSubmitted,
+				"Normal " + EventReasonUnsealed,
+				"Warning " + EventReasonRaftJoinFailed,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, run := tt.run(t)
+			recorder := recordTestEvents(conf)
+
+			err := run()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := recordedEvents(recorder); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recorded events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordEventDryRun(t *testing.T) {
+	clientset, conf := newTestCluster(t, &vaultStub{sealed: true})
+	conf.DryRun = true
+	recorder := recordTestEvents(conf)
+	var output bytes.Buffer
+	originalStdout := stdout
+	stdout = &output
+	t.Cleanup(func() { stdout = originalStdout })
+
+	if err := conf.UnsealRaftLeader(clientset, nil); err != nil {
+		t.Fatalf("UnsealRaftLeader() error = %v", err)
+	}
+	if got := recordedEvents(recorder); len(got) != 0 {
+		t.Errorf("recorded events = %v in dry-run mode, want none", got)
+	}
+}
@@ -0,0 +1,343 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// vaultStub serves the vault api used to initialize, unseal and join a node,
+// along with a logical store for auth methods, policies, users and roles
+//
+// The rest of the api is served by stubRoutes, registered next to the tests
+// of the commands that need it, which keep their state in the embedded stubs
+type vaultStub struct {
+	mu          sync.Mutex
+	initialized bool
+	sealed      bool
+	keys        []string
+	threshold   int
+	progress    int
+	// autoUnseal makes the node unseal itself once initialized or joined, as
+	// with an auto unseal seal, after reporting sealed to sealedReads more
+	// health reads
+	autoUnseal  bool
+	sealedReads int
+	// inits and shares count init and unseal requests, initRequest holds the
+	// body of the last init request and joinedLeader the leader address of a
+	// raft join request
+	inits        int
+	shares       int
+	initRequest  map[string]interface{}
+	joinedLeader string
+	// logical holds the data written to any other path, writes logs every
+	// write in order, and revokedTokens holds the tokens that revoked themselves
+	logical       map[string]map[string]interface{}
+	writes        []stubWrite
+	revokedTokens []string
+
+	rekeyStub
+	generateRootStub
+	sealStub
+	raftStub
+}
+
+// stubWrite is a write to the logical store of a vaultStub
+type stubWrite struct {
+	path string
+	data map[string]interface{}
+}
+
+// stubRoute serves a single vault api path of a vaultStub, with its lock held
+type stubRoute func(stub *vaultStub, w http.ResponseWriter, r *http.Request, body map[string]interface{})
+
+// stubRoutes holds the vault api paths served outside of the core stub
+var stubRoutes = map[string]stubRoute{}
+
+func (stub *vaultStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	var body map[string]interface{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	switch r.URL.Path {
+	case "/v1/sys/health":
+		if stub.autoUnseal && stub.initialized && stub.sealed {
+			stub.sealed = stub.sealedReads > 0
+			stub.sealedReads--
+		}
+		writeStubJSON(w, map[string]interface{}{
+			"initialized":  stub.initialized,
+			"sealed":       stub.sealed,
+			"cluster_name": "vault-cluster-stub",
+			"cluster_id":   "stub",
+		})
+	case "/v1/sys/init":
+		stub.inits++
+		stub.initialized = true
+		stub.initRequest = body
+		keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+		if stub.autoUnseal {
+			writeStubJSON(w, map[string]interface{}{
+				"recovery_keys":        keys,
+				"recovery_keys_base64": keys,
+				"root_token":           "hvs.stubroottoken",
+			})
+			return
+		}
+		stub.keys = keys
+		stub.threshold = int(body["secret_threshold"].(float64))
+		writeStubJSON(w, map[string]interface{}{
+			"keys":        stub.keys,
+			"keys_base64": stub.keys,
+			"root_token":  "hvs.stubroottoken",
+		})
+	case "/v1/sys/seal-status":
+		writeStubJSON(w, stub.sealStatus())
+	case "/v1/sys/unseal":
+		if reset, _ := body["reset"].(bool); reset {
+			stub.progress = 0
+			writeStubJSON(w, stub.sealStatus())
+			return
+		}
+		key, _ := body["key"].(string)
+		if !stub.validKey(key) {
+			w.WriteHeader(http.StatusBadRequest)
+			writeStubJSON(w, map[string]interface{}{"errors": []string{"invalid key"}})
+			return
+		}
+		stub.shares++
+		stub.progress++
+		if stub.progress >= stub.threshold {
+			stub.sealed = false
+			stub.progress = 0
+		}
+		writeStubJSON(w, stub.sealStatus())
+	case "/v1/sys/storage/raft/join":
+		stub.initialized = true
+		stub.joinedLeader, _ = body["leader_api_addr"].(string)
+		writeStubJSON(w, map[string]interface{}{"joined": true})
+	default:
+		if route, ok := stubRoutes[r.URL.Path]; ok {
+			route(stub, w, r, body)
+			return
+		}
+		stub.serveLogical(w, r, strings.TrimPrefix(r.URL.Path, "/v1/"), body)
+	}
+}
+
+// serveLogical serves logins, token self-service, the auth method and mount
+// lists, and reads and writes of any other path from the logical store
+func (stub *vaultStub) serveLogical(w http.ResponseWriter, r *http.Request, path string, body map[string]interface{}) {
+	if stub.logical == nil {
+		stub.logical = make(map[string]map[string]interface{})
+	}
+
+	switch {
+	case strings.HasPrefix(path, "auth/") && strings.Contains(path, "/login/"):
+		user, ok := stub.logical[strings.Replace(path, "/login/", "/users/", 1)]
+		if !ok || user["password"] != body["password"] {
+			w.WriteHeader(http.StatusBadRequest)
+			writeStubJSON(w, map[string]interface{}{"errors": []string{"invalid username or password"}})
+			return
+		}
+		writeStubJSON(w, map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": "hvs.stublogintoken",
+			"policies":     user["token_policies"],
+		}})
+	case path == "auth/token/lookup-self":
+		writeStubJSON(w, map[string]interface{}{"data": map[string]interface{}{"id": r.Header.Get("X-Vault-Token")}})
+	case path == "auth/token/revoke-self":
+		stub.revokedTokens = append(stub.revokedTokens, r.Header.Get("X-Vault-Token"))
+		w.WriteHeader(http.StatusNoContent)
+	case (path == "sys/auth" || path == "sys/mounts") && r.Method == http.MethodGet:
+		mounts := map[string]interface{}{"token/": map[string]interface{}{"type": "token"}}
+		if path == "sys/mounts" {
+			mounts = map[string]interface{}{"secret/": map[string]interface{}{"type": "kv"}}
+		}
+		for key, data := range stub.logical {
+			if strings.HasPrefix(key, path+"/") {
+				mounts[strings.TrimPrefix(key, path+"/")+"/"] = data
+			}
+		}
+		writeStubJSON(w, map[string]interface{}{"data": mounts})
+	case r.Method == http.MethodGet:
+		data, ok := stub.logical[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			writeStubJSON(w, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		writeStubJSON(w, map[string]interface{}{"data": data})
+	case r.Method == http.MethodPut || r.Method == http.MethodPost:
+		// Writes keep the fields they leave out, as vault roles do
+		stub.writes = append(stub.writes, stubWrite{path: path, data: body})
+		if stub.logical[path] == nil {
+			stub.logical[path] = make(map[string]interface{})
+		}
+		for field, value := range body {
+			stub.logical[path][field] = value
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (stub *vaultStub) sealStatus() map[string]interface{} {
+	return map[string]interface{}{
+		"initialized": stub.initialized,
+		"sealed":      stub.sealed,
+		"t":           stub.threshold,
+		"n":           len(stub.keys),
+		"progress":    stub.progress,
+	}
+}
+
+func (stub *vaultStub) validKey(key string) bool {
+	for _, valid := range stub.keys {
+		if key == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func writeStubJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// newTestCluster returns a fake clientset holding a vault StatefulSet with a
+// running Pod per stub, and a configuration whose vault operations reach each
+// Pod's stub
+func newTestCluster(t *testing.T, stubs ...*vaultStub) (*fake.Clientset, *VaultConfiguration) {
+	t.Helper()
+	t.Setenv("VAULT_TOKEN", "")
+
+	replicas := int32(len(stubs))
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "vault"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: "vault-internal",
+			Selector:    &metav1.LabelSelector{MatchLabels: map[string]string{"app": "vault"}},
+		},
+	}
+	objects := []runtime.Object{statefulSet}
+	servers := make(map[string]string)
+	for i, stub := range stubs {
+		server := httptest.NewServer(stub)
+		t.Cleanup(server.Close)
+
+		name := fmt.Sprintf("%s-%v", statefulSet.Name, i)
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: statefulSet.Namespace,
+				Labels:    map[string]string{"app": "vault", "statefulset.kubernetes.io/pod-name": name},
+			},
+			Status: v1.PodStatus{Phase: v1.PodRunning, PodIP: fmt.Sprintf("10.0.0.%v", i+1)},
+		}
+		objects = append(objects, pod)
+		servers[pod.Status.PodIP] = server.URL
+	}
+
+	clientset := fake.NewSimpleClientset(objects...)
+
+	conf := &VaultConfiguration{
+		Namespace:       statefulSet.Namespace,
+		StatefulSetName: statefulSet.Name,
+		KeyStore: KeyStoreOptions{
+			Type:            KeyStoreKubernetes,
+			SecretName:      VaultSecretName,
+			RootTokenKey:    VaultRootTokenKey,
+			UnsealKeyPrefix: VaultUnsealKeyPrefix,
+		},
+		Retry: RetryPolicy{
+			Timeout:        5 * time.Second,
+			MaxAttempts:    1,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+		},
+		RaftJoinTimeout: time.Second,
+		Port:            VaultPort,
+		SecretShares:    3,
+		SecretThreshold: 2,
+		NewOperations: func(config *vaultapi.Config, token string) (VaultOperations, error) {
+			if err := stubAddress(config, servers); err != nil {
+				return nil, err
+			}
+			return NewVaultOperations(config, token)
+		},
+		NewClient: func(config *vaultapi.Config) (*vaultapi.Client, error) {
+			if err := stubAddress(config, servers); err != nil {
+				return nil, err
+			}
+			return vaultapi.NewClient(config)
+		},
+	}
+
+	return clientset, conf
+}
+
+// stubAddress points a vault api configuration for a Pod IP at its stub
+func stubAddress(config *vaultapi.Config, servers map[string]string) error {
+	address, err := url.Parse(config.Address)
+	if err != nil {
+		return err
+	}
+	config.Address = servers[address.Hostname()]
+	return nil
+}
+
+// storeRootToken adds a root token to the stored initialization data
+func storeRootToken(t *testing.T, clientset *fake.Clientset, conf *VaultConfiguration, token string) {
+	t.Helper()
+	keyStore, err := conf.keyStore(clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := keyStore.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.RootToken = token
+	if err := keyStore.Replace(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storeInitData saves initialization data for the stub keys to the key store
+func storeInitData(t *testing.T, clientset *fake.Clientset, conf *VaultConfiguration, keys []string, threshold int) {
+	t.Helper()
+	keyStore, err := conf.keyStore(clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &InitData{
+		Version:         InitDataVersion,
+		InitResponse:    vaultapi.InitResponse{Keys: keys},
+		SecretShares:    len(keys),
+		SecretThreshold: threshold,
+	}
+	if err := keyStore.Save(data); err != nil {
+		t.Fatal(err)
+	}
+}
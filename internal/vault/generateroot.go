@@ -0,0 +1,271 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/kubefirst/vault-handler/internal/logging"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Characters of the one time passwords used by vault 1.0 and later
+const otpCharset string = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// GenerateRoot generates a new root token on the raft leader by submitting the
+// stored unseal shares, or recovery shares when vault is auto unsealed, and
+// writes it to the key store or stdout
+//
+// With TTLRevoke set the token is revoked once it elapses, or sooner when ctx
+// is done, and the root token stored before is put back
+func (conf *VaultConfiguration) GenerateRoot(ctx context.Context, clientset kubernetes.Interface, opts *VaultGenerateRootExecutionOptions) error {
+	switch opts.Output {
+	case InitOutputKeyStore, InitOutputStdout:
+	default:
+		return fmt.Errorf("unsupported output %q, must be one of %s or %s", opts.Output, InitOutputKeyStore, InitOutputStdout)
+	}
+
+	keyStore, err := conf.keyStore(clientset)
+	if err != nil {
+		return err
+	}
+	data, err := keyStore.Load()
+	if err != nil {
+		return err
+	}
+	shares, err := conf.rootGenerationShares(data)
+	if err != nil {
+		return err
+	}
+
+	cluster, err := conf.DiscoverRaftCluster(clientset)
+	if err != nil {
+		return err
+	}
+	node := cluster.Leader()
+	pod, err := conf.nodePod(ctx, clientset, cluster.Namespace, node)
+	if err != nil {
+		return err
+	}
+	vaultClient, err := conf.newVaultOperationsForPod(clientset, pod)
+	if err != nil {
+		return err
+	}
+	logger := log.WithFields(podFields(pod, "generate-root"))
+
+	health, err := nodeHealth(vaultClient, node)
+	if err != nil {
+		return err
+	}
+	if !health.Initialized || health.Sealed {
+		return fmt.Errorf("%s must be initialized and unsealed to generate a root token", node)
+	}
+	status, err := vaultClient.GenerateRootStatus()
+	if err != nil {
+		return fmt.Errorf("error retrieving root token generation status of %s: %s", node, err)
+	}
+	if status.Started {
+		return fmt.Errorf("a root token generation on %s is already in progress, cancel it with vault operator generate-root -cancel", node)
+	}
+	if err := data.upgrade(vaultClient); err != nil {
+		return err
+	}
+
+	if conf.plan("generate a root token on %s with %v stored shares", node, status.Required) {
+		switch opts.Output {
+		case InitOutputKeyStore:
+			conf.plan("write the root token to %s", keyStore)
+		case InitOutputStdout:
+			conf.plan("print the root token to stdout")
+		}
+		if opts.TTLRevoke > 0 {
+			conf.plan("revoke the root token after %s", opts.TTLRevoke)
+		}
+		return nil
+	}
+
+	otp, err := generateOTP(status.OTPLength)
+	if err != nil {
+		return err
+	}
+	attempt, err := vaultClient.GenerateRootInit(otp, "")
+	if err != nil {
+		return fmt.Errorf("error starting root token generation on %s: %s", node, err)
+	}
+	// Vault 1.10 and later generate the one time password themselves
+	if attempt.OTP != "" {
+		otp = attempt.OTP
+	}
+	required := attempt.Required
+	if len(shares) < required {
+		_ = vaultClient.GenerateRootCancel()
+		return fmt.Errorf("%v stored shares are fewer than the %v %s requires", len(shares), required, node)
+	}
+
+	for i, shard := range shares[:required] {
+		logger.Infof("passing shard %v to the root token generation on %s", i+1, node)
+		attempt, err = vaultClient.GenerateRootUpdate(shard, attempt.Nonce)
+		if err != nil {
+			_ = vaultClient.GenerateRootCancel()
+			conf.recordEvent(pod, v1.EventTypeWarning, EventReasonRootTokenFailed, "Error generating a root token: %s", err)
+			return fmt.Errorf("%s rejected shard %v: %s", node, i+1, err)
+		}
+		if attempt.Complete {
+			break
+		}
+	}
+	if !attempt.Complete {
+		_ = vaultClient.GenerateRootCancel()
+		return fmt.Errorf("root token generation on %s didn't complete after %v shares", node, required)
+	}
+
+	encodedToken := attempt.EncodedToken
+	if encodedToken == "" {
+		encodedToken = attempt.EncodedRootToken
+	}
+	token, err := decodeRootToken(encodedToken, otp, status.OTPLength)
+	if err != nil {
+		return err
+	}
+	logging.Redact(token)
+	conf.recordEvent(pod, v1.EventTypeNormal, EventReasonRootTokenGenerated, "Generated a new root token")
+	logger.Infof("generated a new root token on %s", node)
+
+	previousToken, previousCustodian := data.RootToken, data.RootTokenCustodian
+	switch opts.Output {
+	case InitOutputKeyStore:
+		data.RootToken = token
+		data.RootTokenCustodian = ""
+		if err := keyStore.Replace(data); err != nil {
+			return fmt.Errorf("a root token was generated but could not be written to %s: %s", keyStore, err)
+		}
+		logger.Infof("wrote the root token to %s", keyStore)
+	case InitOutputStdout:
+		if _, err := fmt.Fprintln(stdout, token); err != nil {
+			return err
+		}
+	}
+
+	if opts.TTLRevoke <= 0 {
+		return nil
+	}
+	logger.Infof("the root token will be revoked in %s", opts.TTLRevoke)
+	select {
+	case <-time.After(opts.TTLRevoke):
+	case <-ctx.Done():
+		logger.Info("interrupted, revoking the root token now")
+	}
+
+	revokeClient, err := conf.newVaultClientForPod(clientset, pod)
+	if err != nil {
+		return err
+	}
+	revokeClient.SetToken(token)
+	if err := revokeClient.Auth().Token().RevokeSelf(""); err != nil {
+		return fmt.Errorf("error revoking the generated root token: %s", err)
+	}
+	conf.recordEvent(pod, v1.EventTypeNormal, EventReasonRootTokenRevoked, "Revoked the generated root token after %s", opts.TTLRevoke)
+	logger.Info("revoked the generated root token")
+
+	if opts.Output == InitOutputKeyStore {
+		data.RootToken = previousToken
+		data.RootTokenCustodian = previousCustodian
+		if err := keyStore.Replace(data); err != nil {
+			return fmt.Errorf("the generated root token was revoked but the previous root token could not be restored to %s: %s", keyStore, err)
+		}
+		logger.Infof("restored the previous root token to %s", keyStore)
+	}
+	return nil
+}
+
+// rootGenerationShares returns the shares submitted to generate a root token -
+// recovery shares when vault is auto unsealed, and unseal shares otherwise
+func (conf *VaultConfiguration) rootGenerationShares(data *InitData) ([]string, error) {
+	if conf.UnsealKeysFile == "" && data.autoUnsealed() {
+		if data.PGPEncrypted {
+			return nil, fmt.Errorf("stored recovery shares are pgp encrypted for their custodians, supply the decrypted shares with --unseal-keys-file")
+		}
+		if len(data.RecoveryKeys) == 0 {
+			return nil, fmt.Errorf("no recovery shares are stored")
+		}
+		return data.RecoveryKeys, nil
+	}
+	return conf.unsealKeys(data)
+}
+
+// generateOTP returns a one time password for a root token generation - vault
+// 1.0 and later report its length, and earlier releases use 16 random bytes
+func generateOTP(length int) (string, error) {
+	if length == 0 {
+		otp := make([]byte, 16)
+		if _, err := rand.Read(otp); err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(otp), nil
+	}
+
+	return randomString(length)
+}
+
+// randomString returns a random string of letters and digits
+func randomString(length int) (string, error) {
+	result := make([]byte, length)
+	for i := range result {
+		index, err := rand.Int(rand.Reader, big.NewInt(int64(len(otpCharset))))
+		if err != nil {
+			return "", err
+		}
+		result[i] = otpCharset[index.Int64()]
+	}
+	return string(result), nil
+}
+
+// decodeRootToken XORs an encoded root token with the one time password used
+// to generate it - tokens of releases before 1.0 are UUIDs
+func decodeRootToken(encoded string, otp string, otpLength int) (string, error) {
+	if otpLength == 0 {
+		tokenBytes, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", fmt.Errorf("error decoding the encoded root token: %s", err)
+		}
+		otpBytes, err := base64.StdEncoding.DecodeString(otp)
+		if err != nil {
+			return "", fmt.Errorf("error decoding the one time password: %s", err)
+		}
+		decoded, err := xorBytes(tokenBytes, otpBytes)
+		if err != nil {
+			return "", err
+		}
+		if len(decoded) != 16 {
+			return "", fmt.Errorf("decoded root token is %v bytes, not a UUID", len(decoded))
+		}
+		return fmt.Sprintf("%x-%x-%x-%x-%x", decoded[0:4], decoded[4:6], decoded[6:8], decoded[8:10], decoded[10:16]), nil
+	}
+
+	tokenBytes, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("error decoding the encoded root token: %s", err)
+	}
+	decoded, err := xorBytes(tokenBytes, []byte(otp))
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// xorBytes XORs two byte slices of the same length
+func xorBytes(a []byte, b []byte) ([]byte, error) {
+	if len(a) != len(b) {
+		return nil, fmt.Errorf("the encoded root token is %v bytes but the one time password is %v", len(a), len(b))
+	}
+	result := make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+	return result, nil
+}
@@ -0,0 +1,182 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// generateRootStub holds the root token a vaultStub generates once
+// generateRootProgress reaches the threshold
+type generateRootStub struct {
+	rootToken            string
+	generateRootProgress int
+}
+
+func init() {
+	stubRoutes["/v1/sys/generate-root/attempt"] = (*vaultStub).serveGenerateRootAttempt
+	stubRoutes["/v1/sys/generate-root/update"] = (*vaultStub).serveGenerateRootUpdate
+}
+
+// serveGenerateRootAttempt starts, reports and cancels a root token generation
+func (stub *vaultStub) serveGenerateRootAttempt(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	switch r.Method {
+	case http.MethodPut:
+		stub.generateRootProgress = 0
+		writeStubJSON(w, map[string]interface{}{
+			"started":    true,
+			"nonce":      "generate-root-nonce",
+			"required":   stub.threshold,
+			"otp":        strings.Repeat("x", len(stub.rootToken)),
+			"otp_length": len(stub.rootToken),
+		})
+		return
+	case http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeStubJSON(w, map[string]interface{}{"required": stub.threshold, "otp_length": len(stub.rootToken)})
+}
+
+// serveGenerateRootUpdate accepts a share, returning the encoded root token
+// once the threshold is reached
+func (stub *vaultStub) serveGenerateRootUpdate(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	key, _ := body["key"].(string)
+	if !stub.validKey(key) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeStubJSON(w, map[string]interface{}{"errors": []string{"invalid key"}})
+		return
+	}
+	stub.generateRootProgress++
+	if stub.generateRootProgress < stub.threshold {
+		writeStubJSON(w, map[string]interface{}{"nonce": "generate-root-nonce", "progress": stub.generateRootProgress})
+		return
+	}
+	encoded := []byte(stub.rootToken)
+	for i := range encoded {
+		encoded[i] ^= 'x'
+	}
+	writeStubJSON(w, map[string]interface{}{
+		"nonce":         "generate-root-nonce",
+		"complete":      true,
+		"encoded_token": base64.RawStdEncoding.EncodeToString(encoded),
+	})
+}
+
+func TestDecodeRootToken(t *testing.T) {
+	type args struct {
+		encoded   string
+		otp       string
+		otpLength int
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "A token encoded with an otp of the reported length should be decoded",
+			args: args{
+				encoded:   base64.RawStdEncoding.EncodeToString(xorString("hvs.rootroot", "abcdefghijkl")),
+				otp:       "abcdefghijkl",
+				otpLength: 12,
+			},
+			want: "hvs.rootroot",
+		},
+		{
+			name: "A token encoded with a base64 otp should be decoded as a UUID",
+			args: args{
+				encoded:   base64.StdEncoding.EncodeToString(make([]byte, 16)),
+				otp:       base64.StdEncoding.EncodeToString([]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}),
+				otpLength: 0,
+			},
+			want: "01234567-89ab-cdef-0123-456789abcdef",
+		},
+		{
+			name:    "An otp of a different length than the token should fail",
+			args:    args{encoded: base64.RawStdEncoding.EncodeToString([]byte("hvs.root")), otp: "abc", otpLength: 3},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeRootToken(tt.args.encoded, tt.args.otp, tt.args.otpLength)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeRootToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("decodeRootToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateRoot(t *testing.T) {
+	keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+
+	tests := []struct {
+		name   string
+		legacy bool
+	}{
+		{
+			name: "The generated root token should be written to the key store",
+		},
+		{
+			name:   "Initialization data in the original layout should be upgraded with the threshold vault reports",
+			legacy: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := &vaultStub{initialized: true, keys: keys, threshold: 2, generateRootStub: generateRootStub{rootToken: "hvs.generatedroottoken"}}
+			clientset, conf := newTestCluster(t, leader)
+			if tt.legacy {
+				secret := &v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: VaultSecretName, Namespace: conf.Namespace},
+					Data:       map[string][]byte{VaultRootTokenKey: []byte("hvs.legacyroottoken")},
+				}
+				for i, key := range keys {
+					secret.Data[fmt.Sprintf("%s%v", VaultUnsealKeyPrefix, i)] = []byte(key)
+				}
+				if err := kubernetesinternal.CreateSecretV2(clientset, secret); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				storeInitData(t, clientset, conf, keys, 2)
+			}
+
+			err := conf.GenerateRoot(context.Background(), clientset, &VaultGenerateRootExecutionOptions{Output: InitOutputKeyStore})
+			if err != nil {
+				t.Fatalf("GenerateRoot() error = %v", err)
+			}
+
+			keyStore, _ := conf.keyStore(clientset)
+			data, err := keyStore.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.RootToken != leader.rootToken {
+				t.Errorf("stored root token = %q, want %q", data.RootToken, leader.rootToken)
+			}
+			if data.Version != InitDataVersion || data.SecretThreshold != 2 {
+				t.Errorf("stored version = %v and threshold = %v, want %v and 2", data.Version, data.SecretThreshold, InitDataVersion)
+			}
+			if leader.generateRootProgress != 2 {
+				t.Errorf("leader received %v shares, want 2", leader.generateRootProgress)
+			}
+		})
+	}
+}
+
+func xorString(a string, b string) []byte {
+	result, _ := xorBytes([]byte(a), []byte(b))
+	return result
+}
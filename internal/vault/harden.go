@@ -0,0 +1,312 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	"github.com/kubefirst/vault-handler/internal/logging"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Policy granting every capability on every path, written unless a policy
+// file is supplied
+const adminPolicy string = `path "*" {
+  capabilities = ["create", "read", "update", "delete", "list", "sudo"]
+}
+`
+
+// Lifetime of the service account token requested to check a kubernetes auth
+// login, the shortest the API server issues
+const adminLoginTokenExpiration int64 = 600
+
+// Harden replaces the stored root token with an admin identity - using the
+// stored root token, an admin auth method and policy are set up and an admin
+// identity created, and once it is shown to log in with the policy attached
+// the root token is revoked and removed from the key store
+//
+// The unseal or recovery shares are kept, and every step already done is
+// skipped on reruns until the root token is revoked
+func (conf *VaultConfiguration) Harden(ctx context.Context, clientset kubernetes.Interface, opts *VaultHardenExecutionOptions) error {
+	switch opts.AuthMethod {
+	case AuthMethodUserpass:
+	case AuthMethodKubernetes:
+		if opts.ServiceAccount == "" {
+			return fmt.Errorf("a service account must be supplied with --service-account for the %s auth method", AuthMethodKubernetes)
+		}
+	default:
+		return fmt.Errorf("unsupported auth method %q, must be one of %s or %s", opts.AuthMethod, AuthMethodUserpass, AuthMethodKubernetes)
+	}
+	authPath := strings.Trim(opts.AuthPath, "/")
+	if authPath == "" {
+		authPath = opts.AuthMethod
+	}
+	policy, err := opts.policy()
+	if err != nil {
+		return err
+	}
+
+	keyStore, err := conf.keyStore(clientset)
+	if err != nil {
+		return err
+	}
+	data, err := keyStore.Load()
+	if err != nil {
+		return err
+	}
+	switch {
+	case data.RootToken == "" && data.RootTokenRevokedAt != nil:
+		return fmt.Errorf("the root token stored in %s was already revoked at %s", keyStore, data.RootTokenRevokedAt.Format(time.RFC3339))
+	case data.RootToken == "":
+		return fmt.Errorf("no root token is stored in %s, store one with generate-root first", keyStore)
+	case data.RootTokenCustodian != "":
+		return fmt.Errorf("the root token stored in %s is pgp encrypted for %s, store a decrypted one with generate-root first", keyStore, data.RootTokenCustodian)
+	}
+
+	cluster, err := conf.DiscoverRaftCluster(clientset)
+	if err != nil {
+		return err
+	}
+	node := cluster.Leader()
+	pod, err := conf.nodePod(ctx, clientset, cluster.Namespace, node)
+	if err != nil {
+		return err
+	}
+	vaultClient, err := conf.newVaultClientForPod(clientset, pod)
+	if err != nil {
+		return err
+	}
+	vaultClient.SetToken(data.RootToken)
+	logger := log.WithFields(podFields(pod, "harden"))
+
+	if _, err := vaultClient.Auth().Token().LookupSelf(); err != nil {
+		return fmt.Errorf("the root token stored in %s can't be used: %s", keyStore, err)
+	}
+	if err := data.upgrade(vaultClient.Sys()); err != nil {
+		return err
+	}
+
+	// Auth method and policy
+	if err := conf.enableAuthMethod(vaultClient, opts.AuthMethod, authPath, logger); err != nil {
+		return err
+	}
+	if !conf.plan("write policy %s", opts.PolicyName) {
+		logger.Infof("writing policy %s", opts.PolicyName)
+		if err := vaultClient.Sys().PutPolicy(opts.PolicyName, policy); err != nil {
+			return err
+		}
+	}
+
+	// Admin identity
+	var password string
+	switch opts.AuthMethod {
+	case AuthMethodUserpass:
+		password, err = conf.createAdminUser(clientset, vaultClient, authPath, opts, logger)
+	case AuthMethodKubernetes:
+		err = conf.createAdminRole(vaultClient, authPath, opts, logger)
+	}
+	if err != nil {
+		return err
+	}
+
+	if conf.plan("log in as %s with the %s auth method and check it holds policy %s", opts.AdminName, opts.AuthMethod, opts.PolicyName) {
+		conf.plan("revoke the root token")
+		conf.plan("remove the root token from %s", keyStore)
+		return nil
+	}
+	if err := conf.checkAdminLogin(ctx, clientset, vaultClient, authPath, password, opts, logger); err != nil {
+		conf.recordEvent(pod, v1.EventTypeWarning, EventReasonHardenFailed, "Error logging in as the admin identity, the root token was kept: %s", err)
+		return fmt.Errorf("error logging in as %s, the root token was kept: %s", opts.AdminName, err)
+	}
+
+	// Root token
+	if err := vaultClient.Auth().Token().RevokeSelf(""); err != nil {
+		return fmt.Errorf("error revoking the root token: %s", err)
+	}
+	conf.recordEvent(pod, v1.EventTypeNormal, EventReasonRootTokenRevoked, "Revoked the root token, vault is administered through the %s auth method at %s", opts.AuthMethod, authPath)
+	logger.Info("revoked the root token")
+
+	revokedAt := time.Now().UTC()
+	data.RootToken = ""
+	data.RootTokenRevokedAt = &revokedAt
+	if err := keyStore.Replace(data); err != nil {
+		return fmt.Errorf("the root token was revoked but could not be removed from %s: %s", keyStore, err)
+	}
+	logger.Infof("removed the root token from %s", keyStore)
+	logger.Warn("commands that need a token, such as unseal verifying raft followers, now need --token or VAULT_TOKEN")
+	return nil
+}
+
+// policy returns the admin policy, read from PolicyFile when set
+func (opts *VaultHardenExecutionOptions) policy() (string, error) {
+	if opts.PolicyFile == "" {
+		return adminPolicy, nil
+	}
+	content, err := afero.ReadFile(fs, opts.PolicyFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading policy file %s: %s", opts.PolicyFile, err)
+	}
+	return string(content), nil
+}
+
+// createAdminUser creates the admin userpass user holding the admin policy and
+// returns its password
+//
+// The password is read from VAULT_HANDLER_ADMIN_PASSWORD, or reused from the
+// admin Secret, or otherwise generated and written to the admin Secret
+func (conf *VaultConfiguration) createAdminUser(clientset kubernetes.Interface, vaultClient *vaultapi.Client, authPath string, opts *VaultHardenExecutionOptions, logger *log.Entry) (string, error) {
+	password := os.Getenv(AdminPasswordEnv)
+	writeSecret, secretExists := false, false
+	if password == "" {
+		existingSecret, err := kubernetesinternal.GetSecretV2(clientset, conf.Namespace, opts.AdminSecretName)
+		switch {
+		case err == nil:
+			secretExists = true
+			if string(existingSecret.Data[AdminSecretUserKey]) == opts.AdminName {
+				password = string(existingSecret.Data[AdminSecretPassKey])
+			}
+		case !apierrors.IsNotFound(err):
+			// A password that can't be read isn't rotated
+			return "", fmt.Errorf("error reading Secret %s in Namespace %s: %s", opts.AdminSecretName, conf.Namespace, err)
+		}
+		if password == "" {
+			password, err = randomString(AdminPasswordLength)
+			if err != nil {
+				return "", err
+			}
+			writeSecret = true
+		}
+	}
+	logging.Redact(password)
+
+	if !conf.plan("create userpass user %s with policy %s", opts.AdminName, opts.PolicyName) {
+		logger.Infof("creating userpass user %s with policy %s", opts.AdminName, opts.PolicyName)
+		_, err := vaultClient.Logical().Write(fmt.Sprintf("auth/%s/users/%s", authPath, opts.AdminName), map[string]interface{}{
+			"password":       password,
+			"token_policies": []string{opts.PolicyName},
+			"token_ttl":      opts.TokenTTL.String(),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !writeSecret || conf.plan("write the generated password to Secret %s in Namespace %s", opts.AdminSecretName, conf.Namespace) {
+		return password, nil
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opts.AdminSecretName,
+			Namespace: conf.Namespace,
+		},
+		Data: map[string][]byte{
+			AdminSecretUserKey: []byte(opts.AdminName),
+			AdminSecretPassKey: []byte(password),
+		},
+	}
+	if secretExists {
+		return password, kubernetesinternal.UpdateSecretV2(clientset, secret)
+	}
+	return password, kubernetesinternal.CreateSecretV2(clientset, secret)
+}
+
+// createAdminRole creates the admin kubernetes auth role binding the service
+// account to the admin policy, pointing the auth method at KubernetesHost when
+// it has no config yet
+func (conf *VaultConfiguration) createAdminRole(vaultClient *vaultapi.Client, authPath string, opts *VaultHardenExecutionOptions, logger *log.Entry) error {
+	configPath := fmt.Sprintf("auth/%s/config", authPath)
+	existingConfig, err := vaultClient.Logical().Read(configPath)
+	if err != nil {
+		return err
+	}
+	if existingConfig != nil && existingConfig.Data["kubernetes_host"] != nil && existingConfig.Data["kubernetes_host"] != "" {
+		logger.Infof("kubernetes auth method at %s is already configured", authPath)
+	} else if !conf.plan("configure kubernetes auth method at %s with host %s", authPath, opts.KubernetesHost) {
+		// Vault reviews tokens with its own service account token and CA
+		logger.Infof("configuring kubernetes auth method at %s with host %s", authPath, opts.KubernetesHost)
+		_, err = vaultClient.Logical().Write(configPath, map[string]interface{}{
+			"kubernetes_host": opts.KubernetesHost,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	namespace := opts.ServiceAccountNamespace
+	if namespace == "" {
+		namespace = conf.Namespace
+	}
+	if conf.plan("create kubernetes auth role %s bound to service account %s in Namespace %s with policy %s", opts.AdminName, opts.ServiceAccount, namespace, opts.PolicyName) {
+		return nil
+	}
+	logger.Infof("creating kubernetes auth role %s bound to service account %s in Namespace %s", opts.AdminName, opts.ServiceAccount, namespace)
+	_, err = vaultClient.Logical().Write(fmt.Sprintf("auth/%s/role/%s", authPath, opts.AdminName), map[string]interface{}{
+		"bound_service_account_names":      []string{opts.ServiceAccount},
+		"bound_service_account_namespaces": []string{namespace},
+		"token_policies":                   []string{opts.PolicyName},
+		"token_ttl":                        opts.TokenTTL.String(),
+	})
+	return err
+}
+
+// checkAdminLogin logs in as the admin identity, checks the issued token holds
+// the admin policy, then revokes it
+func (conf *VaultConfiguration) checkAdminLogin(ctx context.Context, clientset kubernetes.Interface, vaultClient *vaultapi.Client, authPath string, password string, opts *VaultHardenExecutionOptions, logger *log.Entry) error {
+	loginClient, err := vaultClient.Clone()
+	if err != nil {
+		return err
+	}
+	loginClient.ClearToken()
+
+	var login *vaultapi.Secret
+	switch opts.AuthMethod {
+	case AuthMethodUserpass:
+		login, err = loginClient.Logical().Write(fmt.Sprintf("auth/%s/login/%s", authPath, opts.AdminName), map[string]interface{}{
+			"password": password,
+		})
+	case AuthMethodKubernetes:
+		namespace := opts.ServiceAccountNamespace
+		if namespace == "" {
+			namespace = conf.Namespace
+		}
+		expiration := adminLoginTokenExpiration
+		tokenRequest, requestErr := clientset.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, opts.ServiceAccount, &authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expiration},
+		}, metav1.CreateOptions{})
+		if requestErr != nil {
+			return fmt.Errorf("error requesting a token for service account %s in Namespace %s: %s", opts.ServiceAccount, namespace, requestErr)
+		}
+		logging.Redact(tokenRequest.Status.Token)
+		login, err = loginClient.Logical().Write(fmt.Sprintf("auth/%s/login", authPath), map[string]interface{}{
+			"role": opts.AdminName,
+			"jwt":  tokenRequest.Status.Token,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if login == nil || login.Auth == nil {
+		return fmt.Errorf("login response is empty")
+	}
+	logging.Redact(login.Auth.ClientToken)
+	if !hasPolicy(login.Auth, opts.PolicyName) {
+		return fmt.Errorf("the issued token holds policies %s, not %s", strings.Join(login.Auth.Policies, ", "), opts.PolicyName)
+	}
+	logger.Infof("logged in as %s with policy %s", opts.AdminName, opts.PolicyName)
+
+	loginClient.SetToken(login.Auth.ClientToken)
+	if err := loginClient.Auth().Token().RevokeSelf(""); err != nil {
+		logger.Warnf("error revoking the token issued to check the admin login: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package vault
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+)
+
+func TestHardenRejects(t *testing.T) {
+	keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+	revokedAt := time.Now().UTC()
+
+	tests := []struct {
+		name    string
+		opts    VaultHardenExecutionOptions
+		data    func(data *InitData)
+		wantErr string
+	}{
+		{
+			name:    "An unsupported auth method should be rejected",
+			opts:    VaultHardenExecutionOptions{AuthMethod: "ldap"},
+			wantErr: "unsupported auth method",
+		},
+		{
+			name:    "The kubernetes auth method should require a service account",
+			opts:    VaultHardenExecutionOptions{AuthMethod: AuthMethodKubernetes},
+			wantErr: "--service-account",
+		},
+		{
+			name:    "Hardening without a stored root token should be rejected",
+			opts:    VaultHardenExecutionOptions{AuthMethod: AuthMethodUserpass},
+			wantErr: "no root token is stored",
+		},
+		{
+			name:    "Hardening after the root token was revoked should be rejected",
+			opts:    VaultHardenExecutionOptions{AuthMethod: AuthMethodUserpass},
+			data:    func(data *InitData) { data.RootTokenRevokedAt = &revokedAt },
+			wantErr: "already revoked",
+		},
+		{
+			name: "Hardening with a pgp encrypted root token should be rejected",
+			opts: VaultHardenExecutionOptions{AuthMethod: AuthMethodUserpass},
+			data: func(data *InitData) {
+				data.RootToken = "d2NCTUE="
+				data.RootTokenCustodian = "keybase:ops"
+			},
+			wantErr: "pgp encrypted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset, conf := newTestCluster(t, &vaultStub{initialized: true, keys: keys, threshold: 2})
+			storeInitData(t, clientset, conf, keys, 2)
+			if tt.data != nil {
+				keyStore, _ := conf.keyStore(clientset)
+				data, err := keyStore.Load()
+				if err != nil {
+					t.Fatal(err)
+				}
+				tt.data(data)
+				if err := keyStore.Replace(data); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := conf.Harden(context.Background(), clientset, &tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Harden() error = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHarden(t *testing.T) {
+	t.Setenv(AdminPasswordEnv, "")
+	keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+	leader := &vaultStub{initialized: true, keys: keys, threshold: 2}
+	follower := &vaultStub{initialized: true, keys: keys, threshold: 2}
+	clientset, conf := newTestCluster(t, leader, follower)
+	storeInitData(t, clientset, conf, keys, 2)
+	storeRootToken(t, clientset, conf, "hvs.stubroottoken")
+
+	err := conf.Harden(context.Background(), clientset, &VaultHardenExecutionOptions{
+		AuthMethod:      AuthMethodUserpass,
+		PolicyName:      AdminPolicyName,
+		AdminName:       AdminName,
+		AdminSecretName: AdminSecretName,
+		TokenTTL:        AdminTokenTTL,
+	})
+	if err != nil {
+		t.Fatalf("Harden() error = %v", err)
+	}
+
+	if method := leader.logical["sys/auth/userpass"]; method["type"] != AuthMethodUserpass {
+		t.Errorf("auth method at userpass = %v, want type %s", method, AuthMethodUserpass)
+	}
+	if policy := leader.logical["sys/policies/acl/"+AdminPolicyName]; policy["policy"] != adminPolicy {
+		t.Errorf("policy %s = %v, want the admin policy", AdminPolicyName, policy)
+	}
+	user := leader.logical["auth/userpass/users/"+AdminName]
+	if !reflect.DeepEqual(user["token_policies"], []interface{}{AdminPolicyName}) {
+		t.Errorf("admin user token_policies = %v, want [%s]", user["token_policies"], AdminPolicyName)
+	}
+	wantRevoked := []string{"hvs.stublogintoken", "hvs.stubroottoken"}
+	if !reflect.DeepEqual(leader.revokedTokens, wantRevoked) {
+		t.Errorf("revoked tokens = %v, want the admin login token and then the root token %v", leader.revokedTokens, wantRevoked)
+	}
+
+	secret, err := kubernetesinternal.GetSecretV2(clientset, conf.Namespace, AdminSecretName)
+	if err != nil {
+		t.Fatalf("admin Secret wasn't written: %v", err)
+	}
+	if string(secret.Data[AdminSecretUserKey]) != AdminName || string(secret.Data[AdminSecretPassKey]) != user["password"] {
+		t.Errorf("admin Secret holds user %q with a password other than the admin user's", secret.Data[AdminSecretUserKey])
+	}
+
+	keyStore, _ := conf.keyStore(clientset)
+	data, err := keyStore.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.RootToken != "" || data.RootTokenRevokedAt == nil {
+		t.Errorf("stored root token = %q revoked at %v, want it removed and the revocation recorded", data.RootToken, data.RootTokenRevokedAt)
+	}
+	if !reflect.DeepEqual(data.Keys, keys) {
+		t.Errorf("stored shares = %v, want them kept as %v", data.Keys, keys)
+	}
+
+	// Without the root token, raft verification needs a token to be supplied
+	err = conf.UnsealRaftFollowers(clientset, nil)
+	if err == nil || !strings.Contains(err.Error(), "--skip-raft-verification") {
+		t.Errorf("UnsealRaftFollowers() after hardening error = %v, want raft verification to fail without a token", err)
+	}
+}
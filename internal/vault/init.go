@@ -0,0 +1,192 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// InitRaftLeader initializes the raft leader using shamir seals and persists the
+// initialization data to the configured output without unsealing
+//
+// With AutoUnseal set, vault is initialized with recovery keys instead and,
+// once its seal has unsealed the leader, the raft followers are joined
+func (conf *VaultConfiguration) InitRaftLeader(clientset kubernetes.Interface, opts *VaultInitExecutionOptions) (*vaultapi.InitResponse, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	pgpKeys, rootTokenPGPKey, err := conf.resolvePGPKeys(clientset, opts)
+	if err != nil {
+		return nil, err
+	}
+	shares, threshold, shareType := opts.shares()
+	if len(pgpKeys) > 0 && len(pgpKeys) != shares {
+		return nil, fmt.Errorf("%v pgp keys were supplied but %v %s shares were requested", len(pgpKeys), shares, shareType)
+	}
+
+	// Auto unseal seals reject secret shares, which are only issued by shamir seals
+	initRequest := &vaultapi.InitRequest{
+		SecretShares:    opts.SecretShares,
+		SecretThreshold: opts.SecretThreshold,
+	}
+	if opts.AutoUnseal {
+		initRequest = &vaultapi.InitRequest{
+			RecoveryShares:    opts.RecoveryShares,
+			RecoveryThreshold: opts.RecoveryThreshold,
+		}
+	}
+	var custodians []string
+	for _, key := range pgpKeys {
+		if opts.AutoUnseal {
+			initRequest.RecoveryPGPKeys = append(initRequest.RecoveryPGPKeys, key.Key)
+		} else {
+			initRequest.PGPKeys = append(initRequest.PGPKeys, key.Key)
+		}
+		custodians = append(custodians, key.Custodian)
+	}
+	if rootTokenPGPKey != nil {
+		initRequest.RootTokenPGPKey = rootTokenPGPKey.Key
+	}
+
+	keyStore, err := conf.keyStore(clientset)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Output == InitOutputKeyStore {
+		if err := ensureKeyStoreEmpty(keyStore); err != nil {
+			return nil, err
+		}
+	}
+
+	cluster, err := conf.DiscoverRaftCluster(clientset)
+	if err != nil {
+		return nil, err
+	}
+	node := cluster.Leader()
+
+	pod, err := conf.nodePod(context.Background(), clientset, cluster.Namespace, node)
+	if err != nil {
+		return nil, err
+	}
+
+	vaultClient, err := conf.newVaultOperationsForPod(clientset, pod)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.WithFields(podFields(pod, "init"))
+
+	// Determine vault health
+	health, err := nodeHealth(vaultClient, node)
+	if err != nil {
+		return nil, err
+	}
+	if health.Initialized {
+		return nil, fmt.Errorf("%s is already initialized", node)
+	}
+
+	if conf.plan("initialize %s with %v %s shares and a threshold of %v", node, shares, shareType, threshold) {
+		switch opts.Output {
+		case InitOutputKeyStore:
+			conf.plan("write initialization data to %s", keyStore)
+		case InitOutputStdout:
+			conf.plan("print initialization data to stdout")
+		}
+		if opts.AutoUnseal {
+			conf.plan("wait for %s to be unsealed by its seal", node)
+			data := &InitData{RecoveryShares: opts.RecoveryShares, RecoveryThreshold: opts.RecoveryThreshold}
+			return nil, conf.joinRaftFollowers(clientset, cluster, data)
+		}
+		return nil, nil
+	}
+
+	logger.Infof("initializing vault raft leader %s with %v %s shares and a threshold of %v", node, shares, shareType, threshold)
+	initResponse, err := vaultClient.Init(initRequest)
+	if err != nil {
+		conf.recordEvent(pod, v1.EventTypeWarning, EventReasonInitFailed, "Error initializing vault: %s", err)
+		return nil, err
+	}
+	logger.Infof("vault initialization complete")
+
+	data := newInitData(initRequest, initResponse)
+	if len(pgpKeys) > 0 {
+		logger.Infof("%s shares are pgp encrypted for custodians %s", shareType, strings.Join(custodians, ", "))
+		data.PGPEncrypted = true
+		data.Custodians = custodians
+	}
+	if rootTokenPGPKey != nil {
+		data.RootTokenCustodian = rootTokenPGPKey.Custodian
+	}
+	data.describeCluster(vaultClient)
+	switch opts.Output {
+	case InitOutputKeyStore:
+		if err := keyStore.Save(data); err != nil {
+			// Vault can't be initialized again, so the data is printed rather than lost
+			conf.recordEvent(pod, v1.EventTypeWarning, EventReasonInitFailed, "Vault was initialized but its initialization data could not be written to %s: %s", keyStore, err)
+			if writeErr := writeInitJSON(data); writeErr != nil {
+				return nil, fmt.Errorf("vault was initialized but its initialization data could not be persisted to %s: %s, or printed: %s", keyStore, err, writeErr)
+			}
+			return nil, fmt.Errorf("vault was initialized but its initialization data could not be persisted to %s, it was printed to stdout instead: %s", keyStore, err)
+		}
+	case InitOutputStdout:
+		if err := writeInitJSON(data); err != nil {
+			conf.recordEvent(pod, v1.EventTypeWarning, EventReasonInitFailed, "Vault was initialized but its initialization data could not be printed: %s", err)
+			return nil, fmt.Errorf("vault was initialized but its initialization data could not be printed: %s", err)
+		}
+	}
+	conf.recordEvent(pod, v1.EventTypeNormal, EventReasonInitialized, "Initialized with %v %s shares and a threshold of %v", shares, shareType, threshold)
+
+	if opts.AutoUnseal {
+		if err := conf.waitForAutoUnseal(vaultClient, node); err != nil {
+			return nil, err
+		}
+		if err := conf.joinRaftFollowers(clientset, cluster, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return initResponse, nil
+}
+
+// validate checks that the init options are usable before vault is touched
+func (opts *VaultInitExecutionOptions) validate() error {
+	shares, threshold, shareType := opts.shares()
+	if shares < 1 {
+		return fmt.Errorf("%s shares must be at least 1, got %v", shareType, shares)
+	}
+	if threshold < 1 || threshold > shares {
+		return fmt.Errorf("%s threshold must be between 1 and %v, got %v", shareType, shares, threshold)
+	}
+	switch opts.Output {
+	case InitOutputKeyStore, InitOutputStdout:
+	default:
+		return fmt.Errorf("unsupported output %q, must be one of %s or %s", opts.Output, InitOutputKeyStore, InitOutputStdout)
+	}
+	return nil
+}
+
+// shares returns the share count and threshold requested for the seal type,
+// recovery shares with auto unseal and secret shares otherwise
+func (opts *VaultInitExecutionOptions) shares() (int, int, string) {
+	if opts.AutoUnseal {
+		return opts.RecoveryShares, opts.RecoveryThreshold, "recovery"
+	}
+	return opts.SecretShares, opts.SecretThreshold, "secret"
+}
+
+// writeInitJSON prints vault initialization data as json to stdout
+func writeInitJSON(data *InitData) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(stdout, string(content))
+	return err
+}
@@ -0,0 +1,221 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInitRaftLeader(t *testing.T) {
+	shamir := VaultInitExecutionOptions{SecretShares: 3, SecretThreshold: 2, Output: InitOutputKeyStore}
+
+	tests := []struct {
+		name        string
+		leader      *vaultStub
+		opts        VaultInitExecutionOptions
+		stored      bool
+		wantInits   int
+		wantErr     string
+		wantStdout  bool
+		wantRequest map[string]interface{}
+	}{
+		{
+			name:        "An uninitialized leader should be initialized and its data persisted",
+			leader:      &vaultStub{sealed: true},
+			opts:        shamir,
+			wantInits:   1,
+			wantRequest: map[string]interface{}{"secret_shares": 3.0, "secret_threshold": 2.0, "recovery_shares": 0.0},
+		},
+		{
+			name:    "A key store already holding initialization data should stop init before vault is touched",
+			leader:  &vaultStub{sealed: true},
+			opts:    shamir,
+			stored:  true,
+			wantErr: "already holds initialization data",
+		},
+		{
+			name:    "An already initialized leader should be rejected",
+			leader:  &vaultStub{initialized: true, sealed: true},
+			opts:    shamir,
+			wantErr: "vault-0 is already initialized",
+		},
+		{
+			name:    "A pgp key count that differs from the share count should be rejected",
+			leader:  &vaultStub{sealed: true},
+			opts:    VaultInitExecutionOptions{SecretShares: 3, SecretThreshold: 2, Output: InitOutputKeyStore, PGPKeys: []string{"AQ==", "Ag=="}},
+			wantErr: "2 pgp keys were supplied but 3 secret shares were requested",
+		},
+		{
+			name:       "Initialization data should be printed rather than persisted with stdout output",
+			leader:     &vaultStub{sealed: true},
+			opts:       VaultInitExecutionOptions{SecretShares: 3, SecretThreshold: 2, Output: InitOutputStdout},
+			wantInits:  1,
+			wantStdout: true,
+		},
+		{
+			name:        "An auto unsealed leader should only be sent recovery shares",
+			leader:      &vaultStub{sealed: true, autoUnseal: true, raftStub: raftStub{raftServers: map[string]bool{"vault-0": true}}},
+			opts:        VaultInitExecutionOptions{AutoUnseal: true, RecoveryShares: 5, RecoveryThreshold: 3, Output: InitOutputKeyStore},
+			wantInits:   1,
+			wantRequest: map[string]interface{}{"secret_shares": 0.0, "secret_threshold": 0.0, "recovery_shares": 5.0, "recovery_threshold": 3.0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset, conf := newTestCluster(t, tt.leader)
+			keys := []string{"d4d4d4d4"}
+			if tt.stored {
+				storeInitData(t, clientset, conf, keys, 1)
+			}
+			var output bytes.Buffer
+			originalStdout := stdout
+			stdout = &output
+			t.Cleanup(func() { stdout = originalStdout })
+
+			_, err := conf.InitRaftLeader(clientset, &tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("InitRaftLeader() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("InitRaftLeader() error = %v", err)
+			}
+			if tt.leader.inits != tt.wantInits {
+				t.Errorf("leader received %v init requests, want %v", tt.leader.inits, tt.wantInits)
+			}
+			for field, want := range tt.wantRequest {
+				if got := tt.leader.initRequest[field]; got != want {
+					t.Errorf("init request %s = %v, want %v", field, got, want)
+				}
+			}
+
+			keyStore, _ := conf.keyStore(clientset)
+			data, loadErr := keyStore.Load()
+			switch {
+			case tt.wantStdout:
+				if loadErr == nil {
+					t.Error("initialization data was persisted, want it only printed")
+				}
+				var printed InitData
+				if err := json.Unmarshal(output.Bytes(), &printed); err != nil {
+					t.Fatalf("printed initialization data isn't json: %v\n%s", err, output.String())
+				}
+				if !reflect.DeepEqual(printed.Keys, tt.leader.keys) || printed.RootToken != "hvs.stubroottoken" {
+					t.Errorf("printed keys = %v and root token %q, want %v and the root token", printed.Keys, printed.RootToken, tt.leader.keys)
+				}
+			case tt.stored:
+				if loadErr != nil || !reflect.DeepEqual(data.Keys, keys) {
+					t.Errorf("stored keys = %v (%v), want them left untouched as %v", data, loadErr, keys)
+				}
+			case tt.wantInits > 0:
+				if loadErr != nil {
+					t.Fatalf("initialization data wasn't persisted: %v", loadErr)
+				}
+				if output.Len() > 0 {
+					t.Errorf("initialization data was printed, want it only persisted:\n%s", output.String())
+				}
+				if tt.opts.AutoUnseal && (len(data.RecoveryKeys) != 3 || len(data.Keys) != 0) {
+					t.Errorf("persisted keys = %v and recovery keys %v, want only recovery keys", data.Keys, data.RecoveryKeys)
+				}
+				if !tt.opts.AutoUnseal && !reflect.DeepEqual(data.Keys, tt.leader.keys) {
+					t.Errorf("persisted keys = %v, want %v", data.Keys, tt.leader.keys)
+				}
+			}
+		})
+	}
+}
+
+func TestInitOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    VaultInitExecutionOptions
+		wantErr string
+	}{
+		{
+			name: "Secret shares within bounds should be accepted",
+			opts: VaultInitExecutionOptions{SecretShares: 3, SecretThreshold: 3, Output: InitOutputKeyStore},
+		},
+		{
+			name:    "No secret shares should be rejected",
+			opts:    VaultInitExecutionOptions{SecretThreshold: 1, Output: InitOutputKeyStore},
+			wantErr: "secret shares must be at least 1",
+		},
+		{
+			name:    "A threshold above the share count should be rejected",
+			opts:    VaultInitExecutionOptions{SecretShares: 3, SecretThreshold: 4, Output: InitOutputKeyStore},
+			wantErr: "secret threshold must be between 1 and 3",
+		},
+		{
+			name:    "Recovery shares should be checked with auto unseal, ignoring secret shares",
+			opts:    VaultInitExecutionOptions{AutoUnseal: true, SecretShares: 5, SecretThreshold: 3, RecoveryShares: 1, RecoveryThreshold: 2, Output: InitOutputKeyStore},
+			wantErr: "recovery threshold must be between 1 and 1",
+		},
+		{
+			name:    "An unknown output should be rejected",
+			opts:    VaultInitExecutionOptions{SecretShares: 3, SecretThreshold: 2, Output: "file"},
+			wantErr: "unsupported output",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validate() error = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInitRaftLeaderAutoUnseal(t *testing.T) {
+	opts := &VaultInitExecutionOptions{AutoUnseal: true, RecoveryShares: 3, RecoveryThreshold: 2, Output: InitOutputKeyStore}
+
+	t.Run("The leader should be left to its seal and followers joined without unseal keys", func(t *testing.T) {
+		raftServers := map[string]bool{"vault-0": true, "vault-1": true, "vault-2": true}
+		leader := &vaultStub{sealed: true, autoUnseal: true, sealedReads: 2, raftStub: raftStub{raftServers: raftServers}}
+		waiting := &vaultStub{sealed: true, autoUnseal: true, sealedReads: 1}
+		unsealed := &vaultStub{sealed: true, autoUnseal: true}
+		clientset, conf := newTestCluster(t, leader, waiting, unsealed)
+
+		if _, err := conf.InitRaftLeader(clientset, opts); err != nil {
+			t.Fatalf("InitRaftLeader() error = %v", err)
+		}
+
+		if leader.initRequest["recovery_shares"] != 3.0 || leader.initRequest["secret_shares"] != 0.0 {
+			t.Errorf("init request = %v, want only recovery shares", leader.initRequest)
+		}
+		if leader.sealed {
+			t.Error("leader is still sealed, want it unsealed by its seal before followers join")
+		}
+		wantLeader := "http://vault-0.vault-internal:8200"
+		for name, follower := range map[string]*vaultStub{"vault-1": waiting, "vault-2": unsealed} {
+			if follower.joinedLeader != wantLeader || follower.sealed {
+				t.Errorf("%s joined %q and sealed = %v, want it joined to %q and unsealed by its seal", name, follower.joinedLeader, follower.sealed, wantLeader)
+			}
+			if follower.shares != 0 || follower.inits != 0 {
+				t.Errorf("%s was sent %v unseal shares and %v init requests, want none", name, follower.shares, follower.inits)
+			}
+		}
+	})
+
+	t.Run("A leader its seal never unseals should time out before followers join", func(t *testing.T) {
+		leader := &vaultStub{sealed: true}
+		follower := &vaultStub{sealed: true, autoUnseal: true}
+		clientset, conf := newTestCluster(t, leader, follower)
+
+		_, err := conf.InitRaftLeader(clientset, opts)
+		if err == nil || !strings.Contains(err.Error(), "check its seal configuration") {
+			t.Fatalf("InitRaftLeader() error = %v, want a timeout waiting for the seal", err)
+		}
+		if follower.joinedLeader != "" {
+			t.Errorf("follower joined %q, want it left alone while the leader is sealed", follower.joinedLeader)
+		}
+	})
+}
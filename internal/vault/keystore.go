@@ -0,0 +1,537 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	vaultapi "github.com/hashicorp/vault/api"
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Use afero for file system to allow for easier testing
+var fs afero.Fs = afero.NewOsFs()
+
+// InitData holds the vault initialization material persisted by a KeyStore
+//
+// Keys, KeysB64, RecoveryKeys and RecoveryKeysB64 from the embedded init
+// response are always held in share order
+type InitData struct {
+	// Version identifies the layout of the initialization data
+	Version int `json:"version"`
+	vaultapi.InitResponse
+	SecretShares      int `json:"secret_shares"`
+	SecretThreshold   int `json:"secret_threshold"`
+	RecoveryShares    int `json:"recovery_shares,omitempty"`
+	RecoveryThreshold int `json:"recovery_threshold,omitempty"`
+	// ClusterName and ClusterID are recorded when vault reports them at initialization
+	ClusterName string    `json:"cluster_name,omitempty"`
+	ClusterID   string    `json:"cluster_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// PGPEncrypted is set when the unseal or recovery shares and root token
+	// were encrypted to custodian public keys at initialization
+	PGPEncrypted bool `json:"pgp_encrypted,omitempty"`
+	// Custodians holds the owner of each unseal or recovery share, in share order
+	Custodians []string `json:"custodians,omitempty"`
+	// RootTokenCustodian holds the owner of the root token
+	RootTokenCustodian string `json:"root_token_custodian,omitempty"`
+	// RekeyedAt is set when the unseal shares were last rotated by a rekey
+	RekeyedAt *time.Time `json:"rekeyed_at,omitempty"`
+	// RootTokenRevokedAt is set when hardening revoked the stored root token
+	RootTokenRevokedAt *time.Time `json:"root_token_revoked_at,omitempty"`
+}
+
+// KeyStore persists vault initialization data between runs
+type KeyStore interface {
+	// Save persists initialization data, failing if it already exists
+	Save(data *InitData) error
+	// Load returns previously saved initialization data
+	Load() (*InitData, error)
+	// Replace atomically replaces previously saved initialization data
+	Replace(data *InitData) error
+	// Delete removes previously saved initialization data
+	Delete() error
+	// Backup copies the saved initialization data aside, failing if a backup
+	// already exists
+	Backup() error
+	// RestoreBackup atomically replaces the saved initialization data with
+	// the backup, which is removed
+	RestoreBackup() error
+	// DiscardBackup removes the backup, reporting whether one existed
+	DiscardBackup() (bool, error)
+	// String describes where initialization data is kept
+	String() string
+}
+
+// ensureKeyStoreEmpty checks that keyStore holds no initialization data yet,
+// so the data of a new initialization can't be lost to a failing Save
+func ensureKeyStoreEmpty(keyStore KeyStore) error {
+	_, err := keyStore.Load()
+	switch {
+	case err == nil:
+		return fmt.Errorf("%s already holds initialization data, move it aside before initializing vault", keyStore)
+	case apierrors.IsNotFound(err), errors.Is(err, os.ErrNotExist):
+		return nil
+	default:
+		return fmt.Errorf("error checking %s is empty before initializing vault: %s", keyStore, err)
+	}
+}
+
+// NewKeyStore returns the KeyStore selected by the supplied options
+func NewKeyStore(clientset kubernetes.Interface, opts *KeyStoreOptions) (KeyStore, error) {
+	switch opts.Type {
+	case KeyStoreKubernetes:
+		return &KubernetesSecretKeyStore{
+			Clientset:       clientset,
+			Namespace:       opts.Namespace,
+			SecretName:      opts.SecretName,
+			RootTokenKey:    opts.RootTokenKey,
+			UnsealKeyPrefix: opts.UnsealKeyPrefix,
+		}, nil
+	case KeyStoreFile:
+		if opts.Path == "" {
+			return nil, fmt.Errorf("a path is required when using the %s key store", KeyStoreFile)
+		}
+		return &FileKeyStore{Path: opts.Path}, nil
+	case KeyStoreAge:
+		if opts.Path == "" {
+			return nil, fmt.Errorf("a path is required when using the %s key store", KeyStoreAge)
+		}
+		passphrase := os.Getenv(KeyStorePassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("%s must be set when using the %s key store", KeyStorePassphraseEnv, KeyStoreAge)
+		}
+		return &EncryptedFileKeyStore{Path: opts.Path, Passphrase: passphrase}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key store %q, must be one of %s, %s or %s", opts.Type, KeyStoreKubernetes, KeyStoreFile, KeyStoreAge)
+	}
+}
+
+// keyStore returns the KeyStore configured for this vault, defaulting the
+// Kubernetes Secret to the vault Namespace
+func (conf *VaultConfiguration) keyStore(clientset kubernetes.Interface) (KeyStore, error) {
+	opts := conf.KeyStore
+	if opts.Namespace == "" {
+		opts.Namespace = conf.Namespace
+	}
+	return NewKeyStore(clientset, &opts)
+}
+
+// KubernetesSecretKeyStore stores initialization data in a Kubernetes Secret
+type KubernetesSecretKeyStore struct {
+	Clientset       kubernetes.Interface
+	Namespace       string
+	SecretName      string
+	RootTokenKey    string
+	UnsealKeyPrefix string
+
+	// resourceVersion is the version of the Secret last loaded or written
+	resourceVersion string
+}
+
+// Save creates the Secret containing vault initialization data
+//
+// The full initialization data is written as json alongside the root token
+// and numbered unseal key entries of the original layout, which existing
+// tooling reads directly
+func (store *KubernetesSecretKeyStore) Save(data *InitData) error {
+	dataToWrite, err := store.secretData(data)
+	if err != nil {
+		return err
+	}
+	secret := v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      store.SecretName,
+			Namespace: store.Namespace,
+		},
+		Data: dataToWrite,
+	}
+
+	log.Infof("creating secret %s containing vault initialization data", store.SecretName)
+	return kubernetesinternal.CreateSecretV2(store.Clientset, &secret)
+}
+
+// Replace updates the Secret with new initialization data - the update is
+// rejected if the Secret changed since this store last loaded or wrote it
+func (store *KubernetesSecretKeyStore) Replace(data *InitData) error {
+	dataToWrite, err := store.secretData(data)
+	if err != nil {
+		return err
+	}
+	secret, err := kubernetesinternal.GetSecretV2(store.Clientset, store.Namespace, store.SecretName)
+	if err != nil {
+		return fmt.Errorf("error reading Secret %s in Namespace %s: %s", store.SecretName, store.Namespace, err)
+	}
+	if store.resourceVersion != "" && secret.ResourceVersion != store.resourceVersion {
+		return fmt.Errorf("Secret %s in Namespace %s changed since it was loaded, rerun to act on its current content", store.SecretName, store.Namespace)
+	}
+
+	// The update carries the version read, so a change made in between is
+	// rejected as a conflict
+	secret.Data = dataToWrite
+	updated, err := store.Clientset.CoreV1().Secrets(store.Namespace).Update(context.Background(), secret, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	store.resourceVersion = updated.ResourceVersion
+	log.Infof("updated Secret %s in Namespace %s", store.SecretName, store.Namespace)
+	return nil
+}
+
+// secretData returns the Secret entries holding initialization data
+func (store *KubernetesSecretKeyStore) secretData(data *InitData) (map[string][]byte, error) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dataToWrite := make(map[string][]byte)
+	dataToWrite[VaultInitDataVersionKey] = []byte(strconv.Itoa(data.Version))
+	dataToWrite[VaultInitDataKey] = content
+	// The root token entry is left out once the root token was revoked
+	if data.RootToken != "" {
+		dataToWrite[store.RootTokenKey] = []byte(data.RootToken)
+	}
+	for i, value := range data.Keys {
+		dataToWrite[fmt.Sprintf("%s%v", store.UnsealKeyPrefix, i+1)] = []byte(value)
+	}
+	return dataToWrite, nil
+}
+
+// Load reads vault initialization data from the Secret
+func (store *KubernetesSecretKeyStore) Load() (*InitData, error) {
+	secret, err := kubernetesinternal.GetSecretV2(store.Clientset, store.Namespace, store.SecretName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Secret %s in Namespace %s: %w", store.SecretName, store.Namespace, err)
+	}
+	store.resourceVersion = secret.ResourceVersion
+
+	content := make(map[string]string)
+	for key, value := range secret.Data {
+		content[key] = string(value)
+	}
+	data, err := parseExistingVaultInitSecret(content, store.RootTokenKey, store.UnsealKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Secret %s in Namespace %s: %s", store.SecretName, store.Namespace, err)
+	}
+	if len(data.Keys) == 0 && !data.autoUnsealed() {
+		return nil, fmt.Errorf("no unseal keys found in Secret %s in Namespace %s", store.SecretName, store.Namespace)
+	}
+	return data, nil
+}
+
+// Delete removes the Secret
+func (store *KubernetesSecretKeyStore) Delete() error {
+	return kubernetesinternal.DeleteSecretV2(store.Clientset, store.Namespace, store.SecretName)
+}
+
+// Backup copies the Secret to a backup Secret named with a -backup suffix
+func (store *KubernetesSecretKeyStore) Backup() error {
+	secret, err := kubernetesinternal.GetSecretV2(store.Clientset, store.Namespace, store.SecretName)
+	if err != nil {
+		return fmt.Errorf("error reading Secret %s in Namespace %s: %s", store.SecretName, store.Namespace, err)
+	}
+
+	backup := v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      store.backupName(),
+			Namespace: store.Namespace,
+		},
+		Data: secret.Data,
+	}
+	err = kubernetesinternal.CreateSecretV2(store.Clientset, &backup)
+	if apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("a backup of the initialization data already exists in Secret %s in Namespace %s", backup.Name, store.Namespace)
+	}
+	return err
+}
+
+// RestoreBackup updates the Secret with the content of the backup Secret and
+// deletes the backup
+func (store *KubernetesSecretKeyStore) RestoreBackup() error {
+	backup, err := kubernetesinternal.GetSecretV2(store.Clientset, store.Namespace, store.backupName())
+	if err != nil {
+		return fmt.Errorf("error reading Secret %s in Namespace %s: %s", store.backupName(), store.Namespace, err)
+	}
+	secret, err := kubernetesinternal.GetSecretV2(store.Clientset, store.Namespace, store.SecretName)
+	if err != nil {
+		return fmt.Errorf("error reading Secret %s in Namespace %s: %s", store.SecretName, store.Namespace, err)
+	}
+
+	secret.Data = backup.Data
+	if err := kubernetesinternal.UpdateSecretV2(store.Clientset, secret); err != nil {
+		return err
+	}
+	return kubernetesinternal.DeleteSecretV2(store.Clientset, store.Namespace, backup.Name)
+}
+
+// DiscardBackup deletes the backup Secret
+func (store *KubernetesSecretKeyStore) DiscardBackup() (bool, error) {
+	err := kubernetesinternal.DeleteSecretV2(store.Clientset, store.Namespace, store.backupName())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// String describes the Secret
+func (store *KubernetesSecretKeyStore) String() string {
+	return fmt.Sprintf("Secret %s in Namespace %s", store.SecretName, store.Namespace)
+}
+
+func (store *KubernetesSecretKeyStore) backupName() string {
+	return store.SecretName + KeyStoreBackupSecretSuffix
+}
+
+// FileKeyStore stores initialization data as json in a local file
+type FileKeyStore struct {
+	Path string
+}
+
+// Save writes vault initialization data to the file, readable only by the current user
+func (store *FileKeyStore) Save(data *InitData) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("writing vault initialization data to %s", store.Path)
+	return writeNewFile(store.Path, content)
+}
+
+// Replace atomically replaces the file with new initialization data
+func (store *FileKeyStore) Replace(data *InitData) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("replacing vault initialization data in %s", store.Path)
+	return replaceFile(store.Path, content)
+}
+
+// Load reads vault initialization data from the file
+func (store *FileKeyStore) Load() (*InitData, error) {
+	content, err := afero.ReadFile(fs, store.Path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeInitData(content, store.Path)
+}
+
+// Delete removes the file
+func (store *FileKeyStore) Delete() error {
+	return fs.Remove(store.Path)
+}
+
+// Backup copies the file alongside it with a .backup suffix
+func (store *FileKeyStore) Backup() error {
+	return backupFile(store.Path)
+}
+
+// RestoreBackup moves the backup over the file
+func (store *FileKeyStore) RestoreBackup() error {
+	return restoreFile(store.Path)
+}
+
+// DiscardBackup removes the backup file
+func (store *FileKeyStore) DiscardBackup() (bool, error) {
+	return discardFile(store.Path)
+}
+
+// String describes the file
+func (store *FileKeyStore) String() string {
+	return fmt.Sprintf("file %s", store.Path)
+}
+
+// EncryptedFileKeyStore stores initialization data as json in a local file
+// encrypted with an age passphrase
+type EncryptedFileKeyStore struct {
+	Path       string
+	Passphrase string
+}
+
+// Save encrypts vault initialization data and writes it to the file
+func (store *EncryptedFileKeyStore) Save(data *InitData) error {
+	encrypted, err := store.encrypt(data)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("writing encrypted vault initialization data to %s", store.Path)
+	return writeNewFile(store.Path, encrypted)
+}
+
+// Replace encrypts vault initialization data and atomically replaces the file
+func (store *EncryptedFileKeyStore) Replace(data *InitData) error {
+	encrypted, err := store.encrypt(data)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("replacing encrypted vault initialization data in %s", store.Path)
+	return replaceFile(store.Path, encrypted)
+}
+
+// encrypt returns armored initialization data encrypted with the passphrase
+func (store *EncryptedFileKeyStore) encrypt(data *InitData) ([]byte, error) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, err := age.NewScryptRecipient(store.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	var encrypted bytes.Buffer
+	armorWriter := armor.NewWriter(&encrypted)
+	ageWriter, err := age.Encrypt(armorWriter, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ageWriter.Write(content); err != nil {
+		return nil, err
+	}
+	if err := ageWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := armorWriter.Close(); err != nil {
+		return nil, err
+	}
+	return encrypted.Bytes(), nil
+}
+
+// Load decrypts vault initialization data from the file
+func (store *EncryptedFileKeyStore) Load() (*InitData, error) {
+	file, err := fs.Open(store.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	identity, err := age.NewScryptIdentity(store.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	ageReader, err := age.Decrypt(armor.NewReader(file), identity)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting %s: %s", store.Path, err)
+	}
+	content, err := io.ReadAll(ageReader)
+	if err != nil {
+		return nil, err
+	}
+	return decodeInitData(content, store.Path)
+}
+
+// Delete removes the file
+func (store *EncryptedFileKeyStore) Delete() error {
+	return fs.Remove(store.Path)
+}
+
+// Backup copies the encrypted file alongside it with a .backup suffix
+func (store *EncryptedFileKeyStore) Backup() error {
+	return backupFile(store.Path)
+}
+
+// RestoreBackup moves the backup over the encrypted file
+func (store *EncryptedFileKeyStore) RestoreBackup() error {
+	return restoreFile(store.Path)
+}
+
+// DiscardBackup removes the backup file
+func (store *EncryptedFileKeyStore) DiscardBackup() (bool, error) {
+	return discardFile(store.Path)
+}
+
+// String describes the encrypted file
+func (store *EncryptedFileKeyStore) String() string {
+	return fmt.Sprintf("age encrypted file %s", store.Path)
+}
+
+// writeNewFile writes content to a file that must not already exist
+func writeNewFile(path string, content []byte) error {
+	file, err := fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// replaceFile atomically replaces the content of a file by renaming a
+// temporary file written alongside it over it
+func replaceFile(path string, content []byte) error {
+	temporary := path + ".tmp"
+	if err := fs.Remove(temporary); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := writeNewFile(temporary, content); err != nil {
+		return err
+	}
+	return fs.Rename(temporary, path)
+}
+
+// backupFile copies a file alongside it with a .backup suffix, failing if a
+// backup already exists
+func backupFile(path string) error {
+	content, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return err
+	}
+	err = writeNewFile(path+KeyStoreBackupFileSuffix, content)
+	if os.IsExist(err) {
+		return fmt.Errorf("a backup of the initialization data already exists at %s", path+KeyStoreBackupFileSuffix)
+	}
+	return err
+}
+
+// restoreFile moves the backup of a file over it
+func restoreFile(path string) error {
+	return fs.Rename(path+KeyStoreBackupFileSuffix, path)
+}
+
+// discardFile removes the backup of a file, reporting whether one existed
+func discardFile(path string) (bool, error) {
+	err := fs.Remove(path + KeyStoreBackupFileSuffix)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// decodeInitData parses json encoded vault initialization data
+func decodeInitData(content []byte, source string) (*InitData, error) {
+	data := &InitData{}
+	if err := json.Unmarshal(content, data); err != nil {
+		return nil, fmt.Errorf("error parsing vault initialization data from %s: %s", source, err)
+	}
+	if data.Version > InitDataVersion {
+		return nil, fmt.Errorf("initialization data version %v in %s is newer than the supported version %v", data.Version, source, InitDataVersion)
+	}
+	if len(data.Keys) == 0 && !data.autoUnsealed() {
+		return nil, fmt.Errorf("no unseal keys found in %s", source)
+	}
+	data.redactSecrets()
+	return data, nil
+}
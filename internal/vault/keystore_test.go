@@ -0,0 +1,65 @@
+package vault
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKubernetesSecretKeyStore(t *testing.T) {
+	newSecret := func() *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: VaultSecretName, Namespace: "vault", ResourceVersion: "1"},
+			Data:       map[string][]byte{VaultUnsealKeyPrefix + "1": []byte("a1a1a1a1")},
+		}
+	}
+	newStore := func(clientset *fake.Clientset) *KubernetesSecretKeyStore {
+		return &KubernetesSecretKeyStore{
+			Clientset:       clientset,
+			Namespace:       "vault",
+			SecretName:      VaultSecretName,
+			RootTokenKey:    VaultRootTokenKey,
+			UnsealKeyPrefix: VaultUnsealKeyPrefix,
+		}
+	}
+
+	t.Run("Load should return the error reading the Secret", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newSecret())
+		clientset.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, VaultSecretName, nil)
+		})
+
+		_, err := newStore(clientset).Load()
+		if err == nil || !strings.Contains(err.Error(), "forbidden") {
+			t.Errorf("Load() error = %v, want the forbidden error", err)
+		}
+	})
+
+	t.Run("Replace should be rejected when the Secret changed since it was loaded", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newSecret())
+		store := newStore(clientset)
+		data, err := store.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changed := newSecret()
+		changed.ResourceVersion = "2"
+		if _, err := clientset.CoreV1().Secrets("vault").Update(context.Background(), changed, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.Replace(data)
+		if err == nil || !strings.Contains(err.Error(), "changed since it was loaded") {
+			t.Errorf("Replace() error = %v, want a changed Secret error", err)
+		}
+	})
+}
@@ -0,0 +1,297 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/kubefirst/vault-handler/internal/logging"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+// KubernetesAuthRole binds a service account to policies through a kubernetes
+// auth role
+type KubernetesAuthRole struct {
+	Name           string   `json:"name"`
+	ServiceAccount string   `json:"service_account"`
+	Namespace      string   `json:"namespace"`
+	Policies       []string `json:"policies,omitempty"`
+	// TTL is the ttl of issued tokens, a duration such as 1h - the auth
+	// method default applies when empty
+	TTL string `json:"ttl,omitempty"`
+}
+
+// BootstrapKubernetesAuth enables the kubernetes auth method on the raft
+// leader, points it at the API server the handler runs in, reviewing tokens
+// with the CA and service account token of the handler's own Pod, and creates
+// the roles listed in the roles file
+//
+// The config and every role are compared with what vault holds and only
+// written when they differ, so reruns change nothing
+func (conf *VaultConfiguration) BootstrapKubernetesAuth(clientset kubernetes.Interface, opts *VaultKubernetesAuthExecutionOptions) error {
+	mount := strings.Trim(opts.Mount, "/")
+	if mount == "" {
+		return fmt.Errorf("the kubernetes auth mount can't be empty")
+	}
+	roles, err := loadKubernetesAuthRoles(opts.RolesFile)
+	if err != nil {
+		return err
+	}
+	authConfig, err := kubernetesAuthConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	keyStore, err := conf.keyStore(clientset)
+	if err != nil {
+		return err
+	}
+	// A supplied token makes the stored initialization data unnecessary
+	data, loadErr := keyStore.Load()
+	token, err := conf.token(data)
+	if err != nil {
+		if loadErr != nil {
+			return loadErr
+		}
+		return err
+	}
+
+	cluster, err := conf.DiscoverRaftCluster(clientset)
+	if err != nil {
+		return err
+	}
+	pod, err := conf.nodePod(context.Background(), clientset, cluster.Namespace, cluster.Leader())
+	if err != nil {
+		return err
+	}
+	vaultClient, err := conf.newVaultClientForPod(clientset, pod)
+	if err != nil {
+		return err
+	}
+	vaultClient.SetToken(token)
+	logger := log.WithFields(podFields(pod, "kubernetes-auth"))
+
+	if err := conf.enableAuthMethod(vaultClient, AuthMethodKubernetes, mount, logger); err != nil {
+		return err
+	}
+	if err := conf.configureKubernetesAuth(vaultClient, mount, authConfig, logger); err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if err := conf.writeKubernetesAuthRole(vaultClient, mount, role, logger); err != nil {
+			return fmt.Errorf("error writing kubernetes auth role %s: %s", role.Name, err)
+		}
+	}
+	return nil
+}
+
+// loadKubernetesAuthRoles reads and validates the yaml or json list of roles
+// in path - no roles are created without one
+func loadKubernetesAuthRoles(path string) ([]KubernetesAuthRole, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading roles file %s: %s", path, err)
+	}
+	var roles []KubernetesAuthRole
+	if err := yaml.UnmarshalStrict(content, &roles); err != nil {
+		return nil, fmt.Errorf("error parsing roles file %s: %s", path, err)
+	}
+
+	names := make(map[string]bool)
+	for i, role := range roles {
+		switch {
+		case role.Name == "":
+			return nil, fmt.Errorf("role %v in %s has no name", i+1, path)
+		case names[role.Name]:
+			return nil, fmt.Errorf("role %s is listed more than once in %s", role.Name, path)
+		case role.ServiceAccount == "" || role.Namespace == "":
+			return nil, fmt.Errorf("role %s in %s must set service_account and namespace", role.Name, path)
+		}
+		if role.TTL != "" {
+			if _, err := time.ParseDuration(role.TTL); err != nil {
+				return nil, fmt.Errorf("role %s in %s has an invalid ttl: %s", role.Name, path, err)
+			}
+		}
+		names[role.Name] = true
+	}
+	return roles, nil
+}
+
+// inClusterConfig returns the configuration of the service account the
+// handler runs as, replaced in tests
+var inClusterConfig = rest.InClusterConfig
+
+// kubernetesAuthConfig returns the kubernetes auth config vault reviews tokens
+// with - in cluster, the API server and CA of the handler's own Pod, each
+// overridden by the options when set
+//
+// No reviewer token is written unless one is supplied, so vault reviews
+// tokens with its own service account token, which it rereads as the token
+// rotates. The token of the handler's Pod is only written when asked for, as
+// it expires with the Pod
+//
+// Out of cluster the active Kubernetes configuration holds the operator's own
+// credentials, which must never be written to vault, so the host has to be
+// supplied
+func kubernetesAuthConfig(opts *VaultKubernetesAuthExecutionOptions) (map[string]interface{}, error) {
+	host := opts.KubernetesHost
+	var caCert []byte
+	var jwt string
+	if opts.KubeInClusterConfig {
+		restConfig, err := inClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("error reading the in cluster configuration: %s", err)
+		}
+		if host == "" {
+			host = restConfig.Host
+		}
+		caCert = restConfig.TLSClientConfig.CAData
+		if len(caCert) == 0 && restConfig.TLSClientConfig.CAFile != "" {
+			content, err := afero.ReadFile(fs, restConfig.TLSClientConfig.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading the Kubernetes CA: %s", err)
+			}
+			caCert = content
+		}
+		if opts.HandlerTokenReviewerJWT {
+			jwt = restConfig.BearerToken
+		}
+	} else if opts.KubernetesHost == "" {
+		return nil, fmt.Errorf("--kubernetes-host must be supplied when running outside the cluster, so that the credentials of the local Kubernetes configuration aren't written to vault")
+	} else if opts.HandlerTokenReviewerJWT {
+		return nil, fmt.Errorf("--handler-token-reviewer-jwt is only supported in cluster, supply the reviewer token with --token-reviewer-jwt-file instead")
+	}
+
+	if opts.KubernetesCAFile != "" {
+		content, err := afero.ReadFile(fs, opts.KubernetesCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the Kubernetes CA: %s", err)
+		}
+		caCert = content
+	}
+	if opts.TokenReviewerJWTFile != "" {
+		content, err := afero.ReadFile(fs, opts.TokenReviewerJWTFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the token reviewer JWT: %s", err)
+		}
+		jwt = strings.TrimSpace(string(content))
+	}
+
+	authConfig := map[string]interface{}{"kubernetes_host": host}
+	if len(caCert) > 0 {
+		authConfig["kubernetes_ca_cert"] = string(caCert)
+	}
+	if jwt != "" {
+		logging.Redact(jwt)
+		authConfig["token_reviewer_jwt"] = jwt
+	}
+	return authConfig, nil
+}
+
+// configureKubernetesAuth writes the kubernetes auth config unless vault
+// already holds the same host and CA and no reviewer token
+//
+// Vault resets every field missing from a config write, and never returns the
+// reviewer token, so a config that differs is always written whole, and a
+// supplied reviewer token is always written since it can't be compared
+func (conf *VaultConfiguration) configureKubernetesAuth(vaultClient *vaultapi.Client, mount string, authConfig map[string]interface{}, logger *log.Entry) error {
+	configPath := fmt.Sprintf("auth/%s/config", mount)
+	host := authConfig["kubernetes_host"]
+	existing, err := vaultClient.Logical().Read(configPath)
+	if err != nil {
+		return err
+	}
+	_, wantJWT := authConfig["token_reviewer_jwt"]
+	if existing != nil && !wantJWT {
+		jwtSet, _ := existing.Data["token_reviewer_jwt_set"].(bool)
+		existingCA, _ := existing.Data["kubernetes_ca_cert"].(string)
+		wantCA, _ := authConfig["kubernetes_ca_cert"].(string)
+		if existing.Data["kubernetes_host"] == host && existingCA == wantCA && !jwtSet {
+			logger.Infof("kubernetes auth method at %s is already configured for %s", mount, host)
+			return nil
+		}
+	}
+	if !wantJWT {
+		logger.Info("vault will review tokens with its own service account token, which needs the system:auth-delegator ClusterRole")
+	}
+	if conf.plan("configure kubernetes auth method at %s for %s", mount, host) {
+		return nil
+	}
+	logger.Infof("configuring kubernetes auth method at %s for %s", mount, host)
+	_, err = vaultClient.Logical().Write(configPath, authConfig)
+	return err
+}
+
+// writeKubernetesAuthRole writes the bindings, policies and ttl of a
+// kubernetes auth role that differ from what vault holds - vault keeps the
+// fields of an existing role that a write leaves out
+func (conf *VaultConfiguration) writeKubernetesAuthRole(vaultClient *vaultapi.Client, mount string, role KubernetesAuthRole, logger *log.Entry) error {
+	rolePath := fmt.Sprintf("auth/%s/role/%s", mount, role.Name)
+	policies := append([]string{}, role.Policies...)
+	sort.Strings(policies)
+	var ttl time.Duration
+	if role.TTL != "" {
+		ttl, _ = time.ParseDuration(role.TTL)
+	}
+	changes := map[string]interface{}{
+		"bound_service_account_names":      []string{role.ServiceAccount},
+		"bound_service_account_namespaces": []string{role.Namespace},
+		"token_policies":                   policies,
+		"token_ttl":                        int64(ttl.Seconds()),
+	}
+
+	existing, err := vaultClient.Logical().Read(rolePath)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		for field, value := range changes {
+			if list, ok := value.([]string); ok {
+				existingList := stringList(existing.Data[field])
+				sort.Strings(existingList)
+				if reflect.DeepEqual(existingList, list) {
+					delete(changes, field)
+				}
+			} else if fmt.Sprint(existing.Data[field]) == fmt.Sprint(value) {
+				delete(changes, field)
+			}
+		}
+		if len(changes) == 0 {
+			logger.Infof("kubernetes auth role %s is already up to date", role.Name)
+			return nil
+		}
+	}
+
+	fields := make([]string, 0, len(changes))
+	for field := range changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	if conf.plan("write %s of kubernetes auth role %s bound to service account %s in Namespace %s with policies %s", strings.Join(fields, ", "), role.Name, role.ServiceAccount, role.Namespace, strings.Join(policies, ", ")) {
+		return nil
+	}
+	logger.Infof("writing %s of kubernetes auth role %s bound to service account %s in Namespace %s", strings.Join(fields, ", "), role.Name, role.ServiceAccount, role.Namespace)
+	_, err = vaultClient.Logical().Write(rolePath, changes)
+	return err
+}
+
+// stringList returns the strings of a list in a vault response
+func stringList(value interface{}) []string {
+	items, _ := value.([]interface{})
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		list = append(list, fmt.Sprint(item))
+	}
+	return list
+}
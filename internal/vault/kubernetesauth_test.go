@@ -0,0 +1,285 @@
+package vault
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"k8s.io/client-go/rest"
+)
+
+func TestLoadKubernetesAuthRoles(t *testing.T) {
+	appFS := afero.NewMemMapFs()
+	originalFS := fs
+	fs = appFS
+	t.Cleanup(func() { fs = originalFS })
+
+	tests := []struct {
+		name    string
+		content string
+		want    []KubernetesAuthRole
+		wantErr string
+	}{
+		{
+			name: "A yaml list of roles should be parsed",
+			content: `- name: atlantis
+  service_account: atlantis
+  namespace: atlantis
+  policies: [admin]
+  ttl: 1h
+- name: external-secrets
+  service_account: external-secrets
+  namespace: external-secrets
+`,
+			want: []KubernetesAuthRole{
+				{Name: "atlantis", ServiceAccount: "atlantis", Namespace: "atlantis", Policies: []string{"admin"}, TTL: "1h"},
+				{Name: "external-secrets", ServiceAccount: "external-secrets", Namespace: "external-secrets"},
+			},
+		},
+		{
+			name:    "A role without a namespace should be rejected",
+			content: "- name: atlantis\n  service_account: atlantis\n",
+			wantErr: "must set service_account and namespace",
+		},
+		{
+			name:    "A role listed twice should be rejected",
+			content: "- {name: a, service_account: a, namespace: a}\n- {name: a, service_account: b, namespace: b}\n",
+			wantErr: "more than once",
+		},
+		{
+			name:    "An invalid ttl should be rejected",
+			content: "- {name: a, service_account: a, namespace: a, ttl: soon}\n",
+			wantErr: "invalid ttl",
+		},
+		{
+			name:    "An unknown setting should be rejected",
+			content: "- {name: a, service_account: a, namespace: a, policy: admin}\n",
+			wantErr: "error parsing roles file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := afero.WriteFile(appFS, "/roles.yaml", []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := loadKubernetesAuthRoles("/roles.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadKubernetesAuthRoles() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadKubernetesAuthRoles() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadKubernetesAuthRoles() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubernetesAuthConfig(t *testing.T) {
+	appFS := afero.NewMemMapFs()
+	originalFS, originalInClusterConfig := fs, inClusterConfig
+	fs = appFS
+	inClusterConfig = func() (*rest.Config, error) {
+		return &rest.Config{
+			Host:            "https://10.96.0.1:443",
+			BearerToken:     "handler-token",
+			TLSClientConfig: rest.TLSClientConfig{CAFile: "/ca.crt"},
+		}, nil
+	}
+	t.Cleanup(func() { fs, inClusterConfig = originalFS, originalInClusterConfig })
+	for path, content := range map[string]string{"/ca.crt": "in-cluster-ca", "/reviewer.jwt": "reviewer-token\n"} {
+		if err := afero.WriteFile(appFS, path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		opts    VaultKubernetesAuthExecutionOptions
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name: "In cluster the handler's own host and CA should be used, leaving the reviewer token to vault",
+			opts: VaultKubernetesAuthExecutionOptions{KubeInClusterConfig: true},
+			want: map[string]interface{}{
+				"kubernetes_host":    "https://10.96.0.1:443",
+				"kubernetes_ca_cert": "in-cluster-ca",
+			},
+		},
+		{
+			name: "In cluster the handler's own token should only be used when asked for",
+			opts: VaultKubernetesAuthExecutionOptions{KubeInClusterConfig: true, HandlerTokenReviewerJWT: true},
+			want: map[string]interface{}{
+				"kubernetes_host":    "https://10.96.0.1:443",
+				"kubernetes_ca_cert": "in-cluster-ca",
+				"token_reviewer_jwt": "handler-token",
+			},
+		},
+		{
+			name:    "Out of cluster without a host should be rejected",
+			opts:    VaultKubernetesAuthExecutionOptions{TokenReviewerJWTFile: "/reviewer.jwt"},
+			wantErr: "--kubernetes-host must be supplied",
+		},
+		{
+			name:    "Out of cluster the handler's token should be rejected",
+			opts:    VaultKubernetesAuthExecutionOptions{KubernetesHost: "https://kubernetes.example.com", HandlerTokenReviewerJWT: true},
+			wantErr: "only supported in cluster",
+		},
+		{
+			name: "Out of cluster only the supplied host and reviewer token should be used",
+			opts: VaultKubernetesAuthExecutionOptions{KubernetesHost: "https://kubernetes.example.com", TokenReviewerJWTFile: "/reviewer.jwt"},
+			want: map[string]interface{}{
+				"kubernetes_host":    "https://kubernetes.example.com",
+				"token_reviewer_jwt": "reviewer-token",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := kubernetesAuthConfig(&tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("kubernetesAuthConfig() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("kubernetesAuthConfig() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kubernetesAuthConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubernetesAuthWrites(t *testing.T) {
+	authConfig := map[string]interface{}{
+		"kubernetes_host":    "https://10.96.0.1:443",
+		"kubernetes_ca_cert": "in-cluster-ca",
+	}
+	reviewerConfig := map[string]interface{}{
+		"kubernetes_host":    "https://10.96.0.1:443",
+		"kubernetes_ca_cert": "in-cluster-ca",
+		"token_reviewer_jwt": "reviewer-token",
+	}
+	role := KubernetesAuthRole{Name: "atlantis", ServiceAccount: "atlantis", Namespace: "atlantis", Policies: []string{"admin", "read"}, TTL: "1h"}
+	storedConfig := map[string]interface{}{
+		"kubernetes_host":        "https://10.96.0.1:443",
+		"kubernetes_ca_cert":     "in-cluster-ca",
+		"token_reviewer_jwt_set": false,
+	}
+	storedRole := map[string]interface{}{
+		"bound_service_account_names":      []interface{}{"atlantis"},
+		"bound_service_account_namespaces": []interface{}{"atlantis"},
+		"token_policies":                   []interface{}{"read", "admin"},
+		"token_ttl":                        3600,
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		stored     map[string]interface{}
+		changed    func(stored map[string]interface{})
+		write      func(conf *VaultConfiguration, vaultClient *vaultapi.Client) error
+		wantWrites []stubWrite
+	}{
+		{
+			name:   "An unchanged config should not be written",
+			path:   "auth/kubernetes/config",
+			stored: storedConfig,
+			write: func(conf *VaultConfiguration, vaultClient *vaultapi.Client) error {
+				return conf.configureKubernetesAuth(vaultClient, "kubernetes", authConfig, log.WithField("phase", "test"))
+			},
+		},
+		{
+			name:    "A config with another host should be written whole",
+			path:    "auth/kubernetes/config",
+			stored:  storedConfig,
+			changed: func(stored map[string]interface{}) { stored["kubernetes_host"] = "https://10.96.0.2:443" },
+			write: func(conf *VaultConfiguration, vaultClient *vaultapi.Client) error {
+				return conf.configureKubernetesAuth(vaultClient, "kubernetes", authConfig, log.WithField("phase", "test"))
+			},
+			wantWrites: []stubWrite{{path: "auth/kubernetes/config", data: authConfig}},
+		},
+		{
+			name:    "A config holding an expired handler token should be rewritten without it",
+			path:    "auth/kubernetes/config",
+			stored:  storedConfig,
+			changed: func(stored map[string]interface{}) { stored["token_reviewer_jwt_set"] = true },
+			write: func(conf *VaultConfiguration, vaultClient *vaultapi.Client) error {
+				return conf.configureKubernetesAuth(vaultClient, "kubernetes", authConfig, log.WithField("phase", "test"))
+			},
+			wantWrites: []stubWrite{{path: "auth/kubernetes/config", data: authConfig}},
+		},
+		{
+			name:    "A supplied reviewer token should always be written, since vault never returns it",
+			path:    "auth/kubernetes/config",
+			stored:  storedConfig,
+			changed: func(stored map[string]interface{}) { stored["token_reviewer_jwt_set"] = true },
+			write: func(conf *VaultConfiguration, vaultClient *vaultapi.Client) error {
+				return conf.configureKubernetesAuth(vaultClient, "kubernetes", reviewerConfig, log.WithField("phase", "test"))
+			},
+			wantWrites: []stubWrite{{path: "auth/kubernetes/config", data: reviewerConfig}},
+		},
+		{
+			name:   "An unchanged role should not be written",
+			path:   "auth/kubernetes/role/atlantis",
+			stored: storedRole,
+			write: func(conf *VaultConfiguration, vaultClient *vaultapi.Client) error {
+				return conf.writeKubernetesAuthRole(vaultClient, "kubernetes", role, log.WithField("phase", "test"))
+			},
+		},
+		{
+			name:    "Only the changed fields of a role should be written",
+			path:    "auth/kubernetes/role/atlantis",
+			stored:  storedRole,
+			changed: func(stored map[string]interface{}) { stored["token_policies"] = []interface{}{"read"} },
+			write: func(conf *VaultConfiguration, vaultClient *vaultapi.Client) error {
+				return conf.writeKubernetesAuthRole(vaultClient, "kubernetes", role, log.WithField("phase", "test"))
+			},
+			wantWrites: []stubWrite{{path: "auth/kubernetes/role/atlantis", data: map[string]interface{}{"token_policies": []interface{}{"admin", "read"}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := make(map[string]interface{})
+			for field, value := range tt.stored {
+				stored[field] = value
+			}
+			if tt.changed != nil {
+				tt.changed(stored)
+			}
+			leader := &vaultStub{initialized: true, logical: map[string]map[string]interface{}{tt.path: stored}}
+			clientset, conf := newTestCluster(t, leader)
+			vaultClient, err := conf.newVaultClient(clientset, "http://10.0.0.1:8200")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.write(conf, vaultClient); err != nil {
+				t.Fatalf("write error = %v", err)
+			}
+			if len(leader.writes) != len(tt.wantWrites) {
+				t.Fatalf("vault received writes %+v, want %+v", leader.writes, tt.wantWrites)
+			}
+			for i, write := range leader.writes {
+				want, _ := json.Marshal(tt.wantWrites[i].data)
+				got, _ := json.Marshal(write.data)
+				if write.path != tt.wantWrites[i].path || string(got) != string(want) {
+					t.Errorf("write %v = %s %s, want %s %s", i, write.path, got, tt.wantWrites[i].path, want)
+				}
+			}
+		})
+	}
+}
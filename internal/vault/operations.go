@@ -0,0 +1,49 @@
+package vault
+
+import (
+	"context"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// VaultOperations are the vault api calls used to initialize, unseal, seal,
+// join and rekey vault nodes and to generate root tokens - *vaultapi.Sys
+// implements them
+type VaultOperations interface {
+	Health() (*vaultapi.HealthResponse, error)
+	Init(opts *vaultapi.InitRequest) (*vaultapi.InitResponse, error)
+	UnsealWithContext(ctx context.Context, shard string) (*vaultapi.SealStatusResponse, error)
+	ResetUnsealProcess() (*vaultapi.SealStatusResponse, error)
+	SealStatus() (*vaultapi.SealStatusResponse, error)
+	Seal() error
+	RaftJoin(opts *vaultapi.RaftJoinRequest) (*vaultapi.RaftJoinResponse, error)
+	RekeyStatus() (*vaultapi.RekeyStatusResponse, error)
+	RekeyInit(config *vaultapi.RekeyInitRequest) (*vaultapi.RekeyStatusResponse, error)
+	RekeyUpdate(shard, nonce string) (*vaultapi.RekeyUpdateResponse, error)
+	RekeyVerificationUpdate(shard, nonce string) (*vaultapi.RekeyVerificationUpdateResponse, error)
+	RekeyCancel() error
+	GenerateRootStatus() (*vaultapi.GenerateRootStatusResponse, error)
+	GenerateRootInit(otp, pgpKey string) (*vaultapi.GenerateRootStatusResponse, error)
+	GenerateRootUpdate(shard, nonce string) (*vaultapi.GenerateRootStatusResponse, error)
+	GenerateRootCancel() error
+}
+
+// VaultOperationsFactory returns the vault operations for a node reached with
+// the supplied api configuration, authenticated with token when it is set
+type VaultOperationsFactory func(config *vaultapi.Config, token string) (VaultOperations, error)
+
+// VaultClientFactory returns a vault api client for a node reached with the
+// supplied api configuration
+type VaultClientFactory func(config *vaultapi.Config) (*vaultapi.Client, error)
+
+// NewVaultOperations returns the vault operations of a vault api client
+func NewVaultOperations(config *vaultapi.Config, token string) (VaultOperations, error) {
+	client, err := vaultapi.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		client.SetToken(token)
+	}
+	return client.Sys(), nil
+}
@@ -0,0 +1,200 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	"github.com/kubefirst/vault-handler/internal/logging"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PGPKey holds a public key in the format expected by the vault init api
+// along with the custodian it belongs to
+type PGPKey struct {
+	Custodian string
+	// Key is a base64 encoded binary public key or a keybase:<user> entry
+	Key string
+}
+
+// resolvePGPKeys loads the share and root token public keys from the sources
+// configured on the init options
+func (conf *VaultConfiguration) resolvePGPKeys(clientset kubernetes.Interface, opts *VaultInitExecutionOptions) ([]PGPKey, *PGPKey, error) {
+	sources := 0
+	for _, set := range []bool{len(opts.PGPKeys) > 0, len(opts.PGPKeyFiles) > 0, opts.PGPKeysConfigMap != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, nil, fmt.Errorf("only one of pgp keys, pgp key files or a pgp keys ConfigMap can be supplied")
+	}
+
+	var keys []PGPKey
+	switch {
+	case len(opts.PGPKeys) > 0:
+		for i, key := range opts.PGPKeys {
+			keys = append(keys, PGPKey{Custodian: custodianForEntry(key, fmt.Sprintf("key-%v", i+1)), Key: key})
+		}
+	case len(opts.PGPKeyFiles) > 0:
+		for _, path := range opts.PGPKeyFiles {
+			key, err := readPGPKeyFile(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			keys = append(keys, *key)
+		}
+	case opts.PGPKeysConfigMap != "":
+		configMap, err := kubernetesinternal.ReadConfigMapV2(clientset, conf.Namespace, opts.PGPKeysConfigMap)
+		if err != nil {
+			return nil, nil, err
+		}
+		// ConfigMap keys name the custodians and are sorted so that shares
+		// are assigned in a stable order
+		custodians := make([]string, 0, len(configMap))
+		for custodian := range configMap {
+			custodians = append(custodians, custodian)
+		}
+		sort.Strings(custodians)
+		for _, custodian := range custodians {
+			key, err := encodePGPKey([]byte(configMap[custodian]))
+			if err != nil {
+				return nil, nil, fmt.Errorf("error reading pgp key %s from ConfigMap %s: %s", custodian, opts.PGPKeysConfigMap, err)
+			}
+			keys = append(keys, PGPKey{Custodian: custodian, Key: key})
+		}
+	}
+
+	var rootTokenKey *PGPKey
+	switch {
+	case opts.RootTokenPGPKey != "" && opts.RootTokenPGPKeyFile != "":
+		return nil, nil, fmt.Errorf("only one of a root token pgp key or a root token pgp key file can be supplied")
+	case opts.RootTokenPGPKey != "":
+		rootTokenKey = &PGPKey{Custodian: custodianForEntry(opts.RootTokenPGPKey, "root-token-key"), Key: opts.RootTokenPGPKey}
+	case opts.RootTokenPGPKeyFile != "":
+		key, err := readPGPKeyFile(opts.RootTokenPGPKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		rootTokenKey = key
+	}
+
+	return keys, rootTokenKey, nil
+}
+
+// readPGPKeyFile reads a public key from a file, naming the custodian after the file
+func readPGPKeyFile(path string) (*PGPKey, error) {
+	content, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := encodePGPKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pgp key %s: %s", path, err)
+	}
+	custodian := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &PGPKey{Custodian: custodian, Key: key}, nil
+}
+
+// encodePGPKey converts an armored, base64 encoded, or binary public key, or a
+// keybase:<user> entry, to the format expected by the vault init api
+func encodePGPKey(content []byte) (string, error) {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 {
+		return "", fmt.Errorf("pgp key is empty")
+	}
+
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("keybase:")):
+		return string(trimmed), nil
+	case bytes.HasPrefix(trimmed, []byte("-----BEGIN PGP")):
+		block, err := armor.Decode(bytes.NewReader(trimmed))
+		if err != nil {
+			return "", err
+		}
+		if block.Type != "PGP PUBLIC KEY BLOCK" {
+			return "", fmt.Errorf("expected a PGP PUBLIC KEY BLOCK, got %s", block.Type)
+		}
+		binary, err := io.ReadAll(block.Body)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(binary), nil
+	}
+
+	// Accept keys that are already base64 encoded, otherwise treat the
+	// content as a binary key
+	if _, err := base64.StdEncoding.DecodeString(string(trimmed)); err == nil {
+		return string(trimmed), nil
+	}
+	return base64.StdEncoding.EncodeToString(content), nil
+}
+
+// custodianForEntry names the custodian of a key passed directly on the
+// command line - keybase entries name their user, other keys are named after
+// their position since a base64 encoded key carries no name
+func custodianForEntry(key string, name string) string {
+	if strings.HasPrefix(key, "keybase:") {
+		return key
+	}
+	return name
+}
+
+// unsealKeys returns the shares used to unseal vault - shares decrypted by
+// custodians take precedence over stored shares, and stored shares that were
+// pgp encrypted at initialization can never be replayed
+func (conf *VaultConfiguration) unsealKeys(data *InitData) ([]string, error) {
+	if conf.UnsealKeysFile != "" {
+		keys, err := readUnsealKeysFile(conf.UnsealKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("using %v custodian supplied unseal shares from %s", len(keys), conf.UnsealKeysFile)
+		return keys, nil
+	}
+
+	if data.autoUnsealed() {
+		return nil, fmt.Errorf("vault was initialized with auto unseal and unseals itself through its seal, recovery keys can't unseal it")
+	}
+	if data.PGPEncrypted {
+		return nil, fmt.Errorf("stored unseal shares are pgp encrypted for their custodians, supply the decrypted shares with --unseal-keys-file")
+	}
+	return data.Keys, nil
+}
+
+// readUnsealKeysFile reads one unseal share per line, ignoring blank lines and
+// lines starting with #
+func readUnsealKeysFile(path string) ([]string, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no unseal shares found in %s", path)
+	}
+	logging.Redact(keys...)
+	return keys, nil
+}
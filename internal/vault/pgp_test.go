@@ -0,0 +1,207 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// armorBlock armors content as a block of the given type
+func armorBlock(t *testing.T, blockType string, content []byte) []byte {
+	t.Helper()
+	var armored bytes.Buffer
+	writer, err := armor.Encode(&armored, blockType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return armored.Bytes()
+}
+
+func TestEncodePGPKey(t *testing.T) {
+	binary := []byte{0x99, 0x01, 0x0d, 0x04, 0x63, 0xf1, 0xa2, 0xff}
+	encoded := base64.StdEncoding.EncodeToString(binary)
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "An armored public key should be base64 encoded",
+			content: armorBlock(t, "PGP PUBLIC KEY BLOCK", binary),
+			want:    encoded,
+		},
+		{
+			name:    "A base64 encoded key should be passed through",
+			content: []byte(encoded + "\n"),
+			want:    encoded,
+		},
+		{
+			name:    "A binary key should be base64 encoded",
+			content: binary,
+			want:    encoded,
+		},
+		{
+			name:    "A keybase entry should be passed through",
+			content: []byte("keybase:jdoe\n"),
+			want:    "keybase:jdoe",
+		},
+		{
+			name:    "An armored private key should be rejected",
+			content: armorBlock(t, "PGP PRIVATE KEY BLOCK", binary),
+			wantErr: true,
+		},
+		{
+			name:    "An empty key should be rejected",
+			content: []byte(" \n"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodePGPKey(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("encodePGPKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("encodePGPKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePGPKeys(t *testing.T) {
+	appFS := afero.NewMemMapFs()
+	originalFS := fs
+	fs = appFS
+	t.Cleanup(func() { fs = originalFS })
+	if err := afero.WriteFile(appFS, "/keys/carol.asc", armorBlock(t, "PGP PUBLIC KEY BLOCK", []byte{0x03}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "custodians", Namespace: "vault"},
+		Data:       map[string]string{"bob": "Ag==", "alice": "AQ=="},
+	}
+	clientset := fake.NewSimpleClientset(configMap)
+	conf := &VaultConfiguration{Namespace: "vault"}
+
+	tests := []struct {
+		name          string
+		opts          VaultInitExecutionOptions
+		wantKeys      []PGPKey
+		wantRootToken *PGPKey
+		wantErr       string
+	}{
+		{
+			name:    "Keys from more than one source should be rejected",
+			opts:    VaultInitExecutionOptions{PGPKeys: []string{"AQ=="}, PGPKeysConfigMap: "custodians"},
+			wantErr: "only one of pgp keys",
+		},
+		{
+			name:    "A root token key from more than one source should be rejected",
+			opts:    VaultInitExecutionOptions{RootTokenPGPKey: "AQ==", RootTokenPGPKeyFile: "/keys/carol.asc"},
+			wantErr: "only one of a root token pgp key",
+		},
+		{
+			name:     "ConfigMap keys should be assigned in custodian order",
+			opts:     VaultInitExecutionOptions{PGPKeysConfigMap: "custodians"},
+			wantKeys: []PGPKey{{Custodian: "alice", Key: "AQ=="}, {Custodian: "bob", Key: "Ag=="}},
+		},
+		{
+			name:          "Keys passed on the command line should be named after their position or keybase user",
+			opts:          VaultInitExecutionOptions{PGPKeys: []string{"AQ==", "keybase:jdoe"}, RootTokenPGPKey: "Ag=="},
+			wantKeys:      []PGPKey{{Custodian: "key-1", Key: "AQ=="}, {Custodian: "keybase:jdoe", Key: "keybase:jdoe"}},
+			wantRootToken: &PGPKey{Custodian: "root-token-key", Key: "Ag=="},
+		},
+		{
+			name:     "Key files should be named after the file",
+			opts:     VaultInitExecutionOptions{PGPKeyFiles: []string{"/keys/carol.asc"}},
+			wantKeys: []PGPKey{{Custodian: "carol", Key: "Aw=="}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, rootTokenKey, err := conf.resolvePGPKeys(clientset, &tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolvePGPKeys() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolvePGPKeys() error = %v", err)
+			}
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("resolvePGPKeys() keys = %+v, want %+v", keys, tt.wantKeys)
+			}
+			if !reflect.DeepEqual(rootTokenKey, tt.wantRootToken) {
+				t.Errorf("resolvePGPKeys() root token key = %+v, want %+v", rootTokenKey, tt.wantRootToken)
+			}
+		})
+	}
+}
+
+func TestUnsealKeys(t *testing.T) {
+	appFS := afero.NewMemMapFs()
+	originalFS := fs
+	fs = appFS
+	t.Cleanup(func() { fs = originalFS })
+	if err := afero.WriteFile(appFS, "/shares", []byte("# decrypted by alice and bob\nd4d4d4d4\n\ne5e5e5e5\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := &InitData{InitResponse: vaultapi.InitResponse{Keys: []string{"wcBMA1", "wcBMA2"}}, PGPEncrypted: true}
+
+	tests := []struct {
+		name           string
+		unsealKeysFile string
+		data           *InitData
+		want           []string
+		wantErr        bool
+	}{
+		{
+			name:    "Stored pgp encrypted shares should never be submitted",
+			data:    encrypted,
+			wantErr: true,
+		},
+		{
+			name:           "Custodian decrypted shares should be used for pgp encrypted data",
+			unsealKeysFile: "/shares",
+			data:           encrypted,
+			want:           []string{"d4d4d4d4", "e5e5e5e5"},
+		},
+		{
+			name: "Stored plain shares should be used",
+			data: &InitData{InitResponse: vaultapi.InitResponse{Keys: []string{"a1a1a1a1"}}},
+			want: []string{"a1a1a1a1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &VaultConfiguration{UnsealKeysFile: tt.unsealKeysFile}
+			got, err := conf.unsealKeys(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unsealKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unsealKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
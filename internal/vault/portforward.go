@@ -0,0 +1,78 @@
+package vault
+
+import (
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// EnablePortForward reaches vault Pods through port-forwards over the supplied
+// Kubernetes api configuration rather than by Pod IP, for use from outside the
+// cluster - forwards are closed by ClosePortForwards or when the handler
+// exits fatally
+func (conf *VaultConfiguration) EnablePortForward(restConfig *rest.Config) {
+	conf.forwardMutex.Lock()
+	defer conf.forwardMutex.Unlock()
+
+	conf.restConfig = restConfig
+	conf.portForwards = make(map[string]*podPortForward)
+	log.RegisterExitHandler(conf.ClosePortForwards)
+}
+
+// ClosePortForwards stops every open port-forward
+func (conf *VaultConfiguration) ClosePortForwards() {
+	conf.forwardMutex.Lock()
+	defer conf.forwardMutex.Unlock()
+
+	for name, forward := range conf.portForwards {
+		log.Infof("closing port-forward for %s", name)
+		forward.Stop()
+		delete(conf.portForwards, name)
+	}
+}
+
+// podPortForward tracks the Pod a port-forward was opened to, since a
+// recreated Pod with the same name needs a new one
+type podPortForward struct {
+	*kubernetesinternal.PortForward
+	uid types.UID
+}
+
+// portForwardEnabled returns whether vault Pods are reached through port-forwards
+func (conf *VaultConfiguration) portForwardEnabled() bool {
+	return conf.restConfig != nil
+}
+
+// forwardedPort returns the local port forwarded to the vault api of a Pod,
+// opening a port-forward unless a live one already exists
+func (conf *VaultConfiguration) forwardedPort(clientset kubernetes.Interface, pod *v1.Pod) (int, error) {
+	conf.forwardMutex.Lock()
+	defer conf.forwardMutex.Unlock()
+
+	name := pod.Namespace + "/" + pod.Name
+	if forward, ok := conf.portForwards[name]; ok {
+		select {
+		case <-forward.Done():
+			log.Infof("port-forward for %s closed, reopening", name)
+		default:
+			if forward.uid == pod.UID {
+				return forward.LocalPort, nil
+			}
+			log.Infof("%s was recreated, reopening port-forward", name)
+			forward.Stop()
+		}
+		delete(conf.portForwards, name)
+	}
+
+	log.Infof("starting port-forward for %s", name)
+	forward, err := kubernetesinternal.PortForwardPod(clientset, conf.restConfig, pod, conf.Port)
+	if err != nil {
+		return 0, err
+	}
+	conf.portForwards[name] = &podPortForward{PortForward: forward, uid: pod.UID}
+
+	return forward.LocalPort, nil
+}
@@ -0,0 +1,158 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// raftPeerStatus describes how the leader sees a single expected raft node
+type raftPeerStatus struct {
+	Node    string
+	Joined  bool
+	Voter   bool
+	Healthy bool
+	Status  string
+}
+
+// ready returns whether the node is a healthy voter
+func (peer *raftPeerStatus) ready() bool {
+	return peer.Joined && peer.Voter && peer.Healthy
+}
+
+// String summarizes the peer for error reports
+func (peer *raftPeerStatus) String() string {
+	switch {
+	case !peer.Joined:
+		return fmt.Sprintf("%s: not a raft peer", peer.Node)
+	case !peer.Voter:
+		return fmt.Sprintf("%s: joined as non-voter", peer.Node)
+	case !peer.Healthy:
+		return fmt.Sprintf("%s: unhealthy (autopilot status %q)", peer.Node, peer.Status)
+	default:
+		return fmt.Sprintf("%s: healthy voter", peer.Node)
+	}
+}
+
+// token returns the token used for authenticated requests
+func (conf *VaultConfiguration) token(data *InitData) (string, error) {
+	if conf.Token != "" {
+		return conf.Token, nil
+	}
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	if data == nil || data.RootToken == "" {
+		return "", fmt.Errorf("no vault token was supplied and no root token is stored")
+	}
+	if data.RootTokenCustodian != "" {
+		return "", fmt.Errorf("the stored root token is pgp encrypted for %s, supply a token with --token or VAULT_TOKEN", data.RootTokenCustodian)
+	}
+	return data.RootToken, nil
+}
+
+// waitForRaftVoters polls the leader until every supplied node is a healthy
+// raft voter, returning a per node report on timeout
+func (conf *VaultConfiguration) waitForRaftVoters(leaderClient *vaultapi.Client, nodes []string) error {
+	logger := log.WithField("phase", "verify")
+	deadline := time.Now().Add(conf.RaftJoinTimeout)
+	for {
+		peers, err := raftPeers(leaderClient, nodes)
+		if err == nil {
+			ready := true
+			for _, peer := range peers {
+				if !peer.ready() {
+					ready = false
+				}
+			}
+			if ready {
+				logger.Infof("raft nodes %s are healthy voters", strings.Join(nodes, ", "))
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("timed out after %s waiting for raft membership: %s", conf.RaftJoinTimeout, err)
+			}
+			report := make([]string, 0, len(peers))
+			for _, peer := range peers {
+				report = append(report, peer.String())
+			}
+			return fmt.Errorf("timed out after %s waiting for raft nodes to become healthy voters:\n  %s", conf.RaftJoinTimeout, strings.Join(report, "\n  "))
+		}
+
+		if err != nil {
+			logger.Warnf("error reading raft membership from the leader, retrying: %s", err)
+		} else {
+			logger.Infof("waiting for raft nodes %s to become healthy voters", strings.Join(nodes, ", "))
+		}
+		time.Sleep(raftCheckInterval)
+	}
+}
+
+// raftPeers reads the raft configuration and autopilot state from the leader
+// and reports the membership of each supplied node
+func raftPeers(leaderClient *vaultapi.Client, nodes []string) ([]*raftPeerStatus, error) {
+	secret, err := leaderClient.Logical().Read("sys/storage/raft/configuration")
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data["config"] == nil {
+		return nil, fmt.Errorf("raft configuration response is empty")
+	}
+	content, err := json.Marshal(secret.Data["config"])
+	if err != nil {
+		return nil, err
+	}
+	configuration := RaftConfiguration{}
+	if err := json.Unmarshal(content, &configuration); err != nil {
+		return nil, fmt.Errorf("error parsing raft configuration: %s", err)
+	}
+
+	autopilot, err := leaderClient.Sys().RaftAutopilotState()
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]*raftPeerStatus, 0, len(nodes))
+	for _, node := range nodes {
+		peer := &raftPeerStatus{Node: node}
+		for _, server := range configuration.Servers {
+			if !raftServerIsNode(server.NodeID, server.Address, node) {
+				continue
+			}
+			peer.Joined = true
+			peer.Voter = server.Voter
+			// Autopilot state is absent on vault releases without autopilot,
+			// in which case raft membership alone is trusted
+			peer.Healthy = autopilot == nil
+			if autopilot != nil {
+				if state, ok := autopilot.Servers[server.NodeID]; ok {
+					peer.Healthy = state.Healthy
+					peer.Status = state.Status
+				}
+			}
+		}
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Node < peers[j].Node })
+
+	return peers, nil
+}
+
+// raftServerIsNode returns whether a raft server is the supplied Pod, matching
+// either its node id or the host of its cluster address
+func raftServerIsNode(nodeID string, address string, node string) bool {
+	if nodeID == node {
+		return true
+	}
+	host := strings.Split(address, ":")[0]
+	return strings.Split(host, ".")[0] == node
+}
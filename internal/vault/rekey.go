@@ -0,0 +1,224 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Rekey rotates the unseal keys of the raft leader using the stored shares and
+// atomically replaces the stored initialization data with the new shares
+//
+// The stored initialization data is backed up first and the backup is kept
+// until the new shares are confirmed - with Verify, vault keeps the previous
+// shares active until a threshold of the new shares is submitted back, and
+// otherwise the backup is removed once the new shares unseal vault
+func (conf *VaultConfiguration) Rekey(clientset kubernetes.Interface, opts *VaultRekeyExecutionOptions) error {
+	if opts.SecretShares < 1 {
+		return fmt.Errorf("secret shares must be at least 1, got %v", opts.SecretShares)
+	}
+	if opts.SecretThreshold < 1 || opts.SecretThreshold > opts.SecretShares {
+		return fmt.Errorf("secret threshold must be between 1 and %v, got %v", opts.SecretShares, opts.SecretThreshold)
+	}
+
+	keyStore, err := conf.keyStore(clientset)
+	if err != nil {
+		return err
+	}
+	data, err := keyStore.Load()
+	if err != nil {
+		return err
+	}
+	switch {
+	case data.autoUnsealed():
+		return fmt.Errorf("%s holds recovery keys, which can't be rekeyed as unseal keys", keyStore)
+	case data.PGPEncrypted:
+		return fmt.Errorf("%s holds pgp encrypted shares, rekey them with vault operator rekey -pgp-keys so that the new shares are encrypted to custodians", keyStore)
+	}
+	unsealKeys, err := conf.unsealKeys(data)
+	if err != nil {
+		return err
+	}
+
+	cluster, err := conf.DiscoverRaftCluster(clientset)
+	if err != nil {
+		return err
+	}
+	node := cluster.Leader()
+	pod, err := conf.nodePod(context.Background(), clientset, cluster.Namespace, node)
+	if err != nil {
+		return err
+	}
+	vaultClient, err := conf.newVaultOperationsForPod(clientset, pod)
+	if err != nil {
+		return err
+	}
+	logger := log.WithFields(podFields(pod, "rekey"))
+
+	health, err := nodeHealth(vaultClient, node)
+	if err != nil {
+		return err
+	}
+	if !health.Initialized || health.Sealed {
+		return fmt.Errorf("%s must be initialized and unsealed to be rekeyed", node)
+	}
+	rekeyStatus, err := vaultClient.RekeyStatus()
+	if err != nil {
+		return fmt.Errorf("error retrieving rekey status of %s: %s", node, err)
+	}
+	if rekeyStatus.Started {
+		return fmt.Errorf("a rekey of %s is already in progress, cancel it with vault operator rekey -cancel", node)
+	}
+	if err := data.upgrade(vaultClient); err != nil {
+		return err
+	}
+
+	if conf.plan("back up initialization data in %s", keyStore) {
+		conf.plan("rekey %s to %v secret shares and a threshold of %v", node, opts.SecretShares, opts.SecretThreshold)
+		conf.plan("submit %v of the stored unseal shares", data.SecretThreshold)
+		conf.plan("replace initialization data in %s", keyStore)
+		if opts.Verify {
+			conf.plan("verify the new shares by submitting %v of them", opts.SecretThreshold)
+			conf.plan("remove the backup of the previous initialization data from %s", keyStore)
+		}
+		return nil
+	}
+
+	if err := keyStore.Backup(); err != nil {
+		return fmt.Errorf("%s, remove it once the current shares are confirmed to unseal vault or restore it if they don't", err)
+	}
+	logger.Infof("backed up the previous initialization data in %s", keyStore)
+
+	rekeyed, err := conf.rekeyShares(vaultClient, node, unsealKeys, opts)
+	if err != nil {
+		// Vault still uses the previous shares, which are left in place
+		conf.discardKeyStoreBackup(keyStore, false)
+		conf.recordEvent(pod, v1.EventTypeWarning, EventReasonRekeyFailed, "Error rekeying vault: %s", err)
+		return err
+	}
+
+	rekeyedAt := time.Now().UTC()
+	newData := *data
+	newData.Keys = rekeyed.Keys
+	newData.KeysB64 = rekeyed.KeysB64
+	newData.SecretShares = opts.SecretShares
+	newData.SecretThreshold = opts.SecretThreshold
+	newData.RekeyedAt = &rekeyedAt
+	newData.redactSecrets()
+
+	if err := keyStore.Replace(&newData); err != nil {
+		if rekeyed.VerificationRequired {
+			cancelErr := vaultClient.RekeyCancel()
+			conf.discardKeyStoreBackup(keyStore, false)
+			conf.recordEvent(pod, v1.EventTypeWarning, EventReasonRekeyFailed, "Error persisting the new shares, the rekey was cancelled: %s", err)
+			if cancelErr != nil {
+				return fmt.Errorf("error persisting the new shares to %s: %s, and cancelling the rekey: %s", keyStore, err, cancelErr)
+			}
+			return fmt.Errorf("error persisting the new shares to %s, the rekey was cancelled and vault still uses the previous shares: %s", keyStore, err)
+		}
+		// Vault already uses the new shares, so they are printed rather than lost
+		conf.recordEvent(pod, v1.EventTypeWarning, EventReasonRekeyFailed, "Vault was rekeyed but the new shares could not be persisted: %s", err)
+		if writeErr := writeInitJSON(&newData); writeErr != nil {
+			return fmt.Errorf("vault was rekeyed but the new shares could not be persisted to %s: %s, or printed: %s", keyStore, err, writeErr)
+		}
+		return fmt.Errorf("vault was rekeyed but the new shares could not be persisted to %s, they were printed to stdout instead: %s", keyStore, err)
+	}
+	logger.Infof("replaced initialization data in %s with the new shares", keyStore)
+
+	if !rekeyed.VerificationRequired {
+		conf.recordEvent(pod, v1.EventTypeNormal, EventReasonRekeyed, "Rekeyed to %v secret shares and a threshold of %v", opts.SecretShares, opts.SecretThreshold)
+		logger.Warnf("the new shares weren't verified, the previous initialization data is kept in the backup of %s until they unseal vault", keyStore)
+		return nil
+	}
+
+	if err := conf.verifyRekey(vaultClient, node, rekeyed.Keys, opts.SecretThreshold, rekeyed.VerificationNonce); err != nil {
+		cancelErr := vaultClient.RekeyCancel()
+		restoreErr := keyStore.RestoreBackup()
+		conf.recordEvent(pod, v1.EventTypeWarning, EventReasonRekeyFailed, "Error verifying the new shares, the rekey was cancelled: %s", err)
+		if cancelErr != nil || restoreErr != nil {
+			return fmt.Errorf("error verifying the new shares: %s - cancelling the rekey: %v, restoring the backup of %s: %v", err, cancelErr, keyStore, restoreErr)
+		}
+		return fmt.Errorf("error verifying the new shares, the rekey was cancelled and the previous initialization data restored: %s", err)
+	}
+	conf.recordEvent(pod, v1.EventTypeNormal, EventReasonRekeyed, "Rekeyed to %v secret shares and a threshold of %v, the new shares were verified", opts.SecretShares, opts.SecretThreshold)
+	conf.discardKeyStoreBackup(keyStore, true)
+
+	return nil
+}
+
+// rekeyShares starts a rekey and submits the stored shares up to the current
+// threshold, returning the new shares
+func (conf *VaultConfiguration) rekeyShares(vaultClient VaultOperations, node string, unsealKeys []string, opts *VaultRekeyExecutionOptions) (*vaultapi.RekeyUpdateResponse, error) {
+	logger := log.WithFields(log.Fields{"node": node, "phase": "rekey"})
+	rekeyStatus, err := vaultClient.RekeyInit(&vaultapi.RekeyInitRequest{
+		SecretShares:        opts.SecretShares,
+		SecretThreshold:     opts.SecretThreshold,
+		RequireVerification: opts.Verify,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error starting a rekey of %s: %s", node, err)
+	}
+	if len(unsealKeys) < rekeyStatus.Required {
+		_ = vaultClient.RekeyCancel()
+		return nil, fmt.Errorf("%v stored unseal shares are fewer than the %v %s requires", len(unsealKeys), rekeyStatus.Required, node)
+	}
+
+	for i, shard := range unsealKeys[:rekeyStatus.Required] {
+		logger.Infof("passing unseal shard %v to the rekey of %s", i+1, node)
+		rekeyed, err := vaultClient.RekeyUpdate(shard, rekeyStatus.Nonce)
+		if err != nil {
+			_ = vaultClient.RekeyCancel()
+			return nil, fmt.Errorf("%s rejected unseal shard %v: %s", node, i+1, err)
+		}
+		if rekeyed.Complete {
+			logger.Infof("%s generated %v new shares", node, len(rekeyed.Keys))
+			return rekeyed, nil
+		}
+	}
+
+	_ = vaultClient.RekeyCancel()
+	return nil, fmt.Errorf("the rekey of %s didn't complete after %v unseal shares", node, rekeyStatus.Required)
+}
+
+// verifyRekey submits new shares up to the new threshold, after which vault
+// stops accepting the previous shares
+func (conf *VaultConfiguration) verifyRekey(vaultClient VaultOperations, node string, newKeys []string, threshold int, nonce string) error {
+	logger := log.WithFields(log.Fields{"node": node, "phase": "rekey"})
+	if len(newKeys) < threshold {
+		return fmt.Errorf("%v new shares are fewer than the threshold of %v", len(newKeys), threshold)
+	}
+
+	for i, shard := range newKeys[:threshold] {
+		logger.Infof("verifying new shard %v with %s", i+1, node)
+		verification, err := vaultClient.RekeyVerificationUpdate(shard, nonce)
+		if err != nil {
+			return fmt.Errorf("%s rejected new shard %v: %s", node, i+1, err)
+		}
+		if verification.Complete {
+			logger.Infof("%s verified the new shares", node)
+			return nil
+		}
+	}
+	return fmt.Errorf("verification of the new shares with %s didn't complete after %v shares", node, threshold)
+}
+
+// discardKeyStoreBackup removes the backup a rekey keeps of the previous
+// initialization data once the stored shares are confirmed
+func (conf *VaultConfiguration) discardKeyStoreBackup(keyStore KeyStore, confirmed bool) {
+	if conf.DryRun {
+		return
+	}
+	discarded, err := keyStore.DiscardBackup()
+	if err != nil {
+		log.Warnf("error removing the backup of the previous initialization data from %s: %s", keyStore, err)
+		return
+	}
+	if discarded && confirmed {
+		log.Infof("the stored shares are confirmed, removed the backup of the previous initialization data from %s", keyStore)
+	}
+}
@@ -0,0 +1,178 @@
+package vault
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// rekeyStub holds the rekey in progress of a vaultStub, and
+// rejectVerification fails verification of the new shares
+type rekeyStub struct {
+	rekey              *stubRekey
+	rejectVerification bool
+}
+
+// stubRekey is a rekey in progress, holding the new shares once generated
+type stubRekey struct {
+	shares, threshold int
+	verify            bool
+	progress          int
+	newKeys           []string
+	verifyProgress    int
+}
+
+func init() {
+	stubRoutes["/v1/sys/rekey/init"] = (*vaultStub).serveRekeyInit
+	stubRoutes["/v1/sys/rekey/update"] = (*vaultStub).serveRekeyUpdate
+	stubRoutes["/v1/sys/rekey/verify"] = (*vaultStub).serveRekeyVerify
+}
+
+// serveRekeyInit starts, reports and cancels a rekey
+func (stub *vaultStub) serveRekeyInit(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	switch r.Method {
+	case http.MethodPut:
+		stub.rekey = &stubRekey{
+			shares:    int(body["secret_shares"].(float64)),
+			threshold: int(body["secret_threshold"].(float64)),
+			verify:    body["require_verification"].(bool),
+		}
+	case http.MethodDelete:
+		stub.rekey = nil
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeStubJSON(w, map[string]interface{}{
+		"started":  stub.rekey != nil,
+		"nonce":    "rekey-nonce",
+		"required": stub.threshold,
+	})
+}
+
+// serveRekeyUpdate accepts a current share, generating the new shares once
+// the threshold is reached
+func (stub *vaultStub) serveRekeyUpdate(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	key, _ := body["key"].(string)
+	if stub.rekey == nil || !stub.validKey(key) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeStubJSON(w, map[string]interface{}{"errors": []string{"invalid key"}})
+		return
+	}
+	stub.rekey.progress++
+	if stub.rekey.progress < stub.threshold {
+		writeStubJSON(w, map[string]interface{}{"nonce": "rekey-nonce"})
+		return
+	}
+	for i := 0; i < stub.rekey.shares; i++ {
+		stub.rekey.newKeys = append(stub.rekey.newKeys, fmt.Sprintf("d4d4d4d4%v", i))
+	}
+	if !stub.rekey.verify {
+		stub.keys, stub.threshold, stub.rekey = stub.rekey.newKeys, stub.rekey.threshold, nil
+	}
+	writeStubJSON(w, map[string]interface{}{
+		"nonce":                 "rekey-nonce",
+		"complete":              true,
+		"keys":                  stub.newKeys(),
+		"keys_base64":           stub.newKeys(),
+		"verification_required": stub.rekey != nil,
+		"verification_nonce":    "verify-nonce",
+	})
+}
+
+// serveRekeyVerify accepts a new share, completing the rekey once the new
+// threshold is reached
+func (stub *vaultStub) serveRekeyVerify(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	if stub.rekey == nil || stub.rejectVerification || body["nonce"] != "verify-nonce" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeStubJSON(w, map[string]interface{}{"errors": []string{"invalid verification"}})
+		return
+	}
+	stub.rekey.verifyProgress++
+	complete := stub.rekey.verifyProgress >= stub.rekey.threshold
+	if complete {
+		stub.keys, stub.threshold, stub.rekey = stub.rekey.newKeys, stub.rekey.threshold, nil
+	}
+	writeStubJSON(w, map[string]interface{}{"nonce": "verify-nonce", "complete": complete})
+}
+
+// newKeys returns the shares generated by the rekey, which are the current
+// shares once it completed without verification
+func (stub *vaultStub) newKeys() []string {
+	if stub.rekey != nil {
+		return stub.rekey.newKeys
+	}
+	return stub.keys
+}
+
+func TestRekey(t *testing.T) {
+	keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+
+	tests := []struct {
+		name               string
+		verify             bool
+		rejectVerification bool
+		wantErr            bool
+		wantRekeyed        bool
+		wantBackup         bool
+	}{
+		{
+			name:        "Verified shares should replace the stored shares and remove the backup",
+			verify:      true,
+			wantRekeyed: true,
+		},
+		{
+			name:               "Shares failing verification should leave the stored shares in place",
+			verify:             true,
+			rejectVerification: true,
+			wantErr:            true,
+		},
+		{
+			name:        "Unverified shares should replace the stored shares and keep the backup",
+			wantRekeyed: true,
+			wantBackup:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leader := &vaultStub{initialized: true, keys: keys, threshold: 2, rekeyStub: rekeyStub{rejectVerification: tt.rejectVerification}}
+			clientset, conf := newTestCluster(t, leader)
+			storeInitData(t, clientset, conf, keys, 2)
+
+			err := conf.Rekey(clientset, &VaultRekeyExecutionOptions{SecretShares: 4, SecretThreshold: 3, Verify: tt.verify})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Rekey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			wantKeys := keys
+			if tt.wantRekeyed {
+				wantKeys = leader.keys
+				if len(wantKeys) != 4 || leader.threshold != 3 {
+					t.Errorf("vault has %v shares and a threshold of %v, want 4 and 3", len(wantKeys), leader.threshold)
+				}
+			} else if !reflect.DeepEqual(leader.keys, keys) {
+				t.Errorf("vault shares = %v, want the previous shares %v", leader.keys, keys)
+			}
+
+			keyStore, _ := conf.keyStore(clientset)
+			data, err := keyStore.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(data.Keys, wantKeys) {
+				t.Errorf("stored shares = %v, want %v", data.Keys, wantKeys)
+			}
+
+			_, err = kubernetesinternal.GetSecretV2(clientset, conf.Namespace, VaultSecretName+KeyStoreBackupSecretSuffix)
+			if tt.wantBackup != (err == nil) {
+				t.Errorf("backup Secret exists = %v, want %v", err == nil, tt.wantBackup)
+			}
+			if err != nil && !apierrors.IsNotFound(err) {
+				t.Error(err)
+			}
+		})
+	}
+}
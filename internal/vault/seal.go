@@ -0,0 +1,172 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// NodeSealResult reports the outcome of sealing a single vault node
+type NodeSealResult struct {
+	Node   string `json:"node"`
+	PodIP  string `json:"pod_ip"`
+	Result string `json:"result"`
+	// Attempts counts the seal requests sent to the node
+	Attempts int `json:"attempts"`
+	// Error holds the reason the node wasn't confirmed sealed
+	Error string `json:"error,omitempty"`
+}
+
+// Sealed returns whether the node was confirmed sealed
+func (result *NodeSealResult) Sealed() bool {
+	return result.Result == SealResultSealed || result.Result == SealResultAlreadySealed
+}
+
+// SealRaftCluster seals every node of the vault StatefulSet concurrently,
+// authenticating with the supplied token or the stored root token, and
+// returns the outcome for each node
+//
+// Vault refuses to seal standby nodes, so every node is retried until it
+// reports sealed or opts.Timeout elapses - a standby becomes active, and can be
+// sealed, once the nodes ahead of it are sealed
+func (conf *VaultConfiguration) SealRaftCluster(ctx context.Context, clientset kubernetes.Interface, opts *VaultSealExecutionOptions) ([]NodeSealResult, error) {
+	keyStore, err := conf.keyStore(clientset)
+	if err != nil {
+		return nil, err
+	}
+	// A supplied token makes the stored initialization data unnecessary
+	data, loadErr := keyStore.Load()
+	token, err := conf.token(data)
+	if err != nil {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return nil, err
+	}
+
+	cluster, err := conf.DiscoverRaftCluster(clientset)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	results := make([]NodeSealResult, len(cluster.Nodes))
+	var wg sync.WaitGroup
+	for i, node := range cluster.Nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			results[i] = conf.sealNode(ctx, clientset, cluster, node, token)
+		}(i, node)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// sealNode seals a single node, retrying until it reports sealed or ctx is
+// done, and records rather than returns errors so that every node is attempted
+func (conf *VaultConfiguration) sealNode(ctx context.Context, clientset kubernetes.Interface, cluster *RaftCluster, node string, token string) NodeSealResult {
+	result := NodeSealResult{Node: node, Result: SealResultFailed}
+
+	pod, err := conf.nodePod(ctx, clientset, cluster.Namespace, node)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.PodIP = pod.Status.PodIP
+	logger := log.WithFields(podFields(pod, "seal"))
+
+	vaultClient, err := conf.newAuthenticatedOperationsForPod(clientset, pod, token)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	status, err := vaultClient.SealStatus()
+	if err != nil {
+		result.Error = fmt.Sprintf("error retrieving seal status: %s", err)
+		return result
+	}
+	if status.Sealed {
+		logger.Infof("%s is already sealed", node)
+		result.Result = SealResultAlreadySealed
+		return result
+	}
+	if conf.plan("seal %s", node) {
+		result.Result = SealResultPlanned
+		return result
+	}
+
+	for {
+		result.Attempts++
+		err = vaultClient.Seal()
+		if err == nil {
+			status, err = vaultClient.SealStatus()
+			if err == nil && !status.Sealed {
+				err = fmt.Errorf("%s still reports unsealed", node)
+			}
+		}
+		if err == nil {
+			conf.recordEvent(pod, v1.EventTypeNormal, EventReasonSealed, "Sealed by an emergency seal")
+			logger.Infof("sealed %s", node)
+			result.Result = SealResultSealed
+			return result
+		}
+
+		logger.Warnf("error sealing %s, retrying as standby nodes can only be sealed once active: %s", node, err)
+		select {
+		case <-ctx.Done():
+			conf.recordEvent(pod, v1.EventTypeWarning, EventReasonSealFailed, "Error sealing vault: %s", err)
+			result.Error = err.Error()
+			return result
+		case <-time.After(sealRetryInterval):
+		}
+	}
+}
+
+// WriteSealResults renders seal results as a table, json, or yaml
+func WriteSealResults(w io.Writer, results []NodeSealResult, format string) error {
+	switch format {
+	case OutputTable:
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "NODE\tPOD IP\tRESULT\tATTEMPTS\tERROR")
+		for _, result := range results {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%v\t%s\n",
+				result.Node,
+				result.PodIP,
+				result.Result,
+				result.Attempts,
+				result.Error,
+			)
+		}
+		return table.Flush()
+	case OutputJSON:
+		content, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(content))
+		return err
+	case OutputYAML:
+		content, err := yaml.Marshal(results)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	default:
+		return fmt.Errorf("unsupported output %q, must be one of %s, %s or %s", format, OutputTable, OutputJSON, OutputYAML)
+	}
+}
@@ -0,0 +1,65 @@
+package vault
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sealStub counts the seal requests of a vaultStub in seals, rejecting the
+// first standbySeals of them as a standby would
+type sealStub struct {
+	standbySeals int
+	seals        int
+}
+
+func init() {
+	stubRoutes["/v1/sys/seal"] = (*vaultStub).serveSeal
+}
+
+// serveSeal seals the node once it stops acting as a standby
+func (stub *vaultStub) serveSeal(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	stub.seals++
+	if stub.seals <= stub.standbySeals {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeStubJSON(w, map[string]interface{}{"errors": []string{"vault cannot seal when in standby mode; please restart instead"}})
+		return
+	}
+	stub.sealed = true
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestSealRaftCluster(t *testing.T) {
+	keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+	leader := &vaultStub{initialized: true, keys: keys, threshold: 2}
+	standby := &vaultStub{initialized: true, keys: keys, threshold: 2, sealStub: sealStub{standbySeals: 1}}
+	sealed := &vaultStub{initialized: true, sealed: true, keys: keys, threshold: 2}
+
+	clientset, conf := newTestCluster(t, leader, standby, sealed)
+	conf.Token = "hvs.stubroottoken"
+
+	results, err := conf.SealRaftCluster(context.Background(), clientset, &VaultSealExecutionOptions{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("SealRaftCluster() error = %v", err)
+	}
+
+	var got []string
+	for _, result := range results {
+		got = append(got, result.Result)
+		if !result.Sealed() {
+			t.Errorf("%s wasn't sealed: %s", result.Node, result.Error)
+		}
+	}
+	want := []string{SealResultSealed, SealResultSealed, SealResultAlreadySealed}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SealRaftCluster() results = %v, want %v", got, want)
+	}
+	if standby.seals != standby.standbySeals+1 {
+		t.Errorf("standby received %v seal requests, want %v", standby.seals, standby.standbySeals+1)
+	}
+	if sealed.seals != 0 {
+		t.Errorf("an already sealed node received %v seal requests, want none", sealed.seals)
+	}
+}
@@ -0,0 +1,150 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// NodeStatus describes the state of a single vault raft node as seen by the handler
+type NodeStatus struct {
+	Node  string `json:"node"`
+	PodIP string `json:"pod_ip"`
+	Phase string `json:"phase"`
+	HealthResponse
+	UnsealProgress  int    `json:"unseal_progress"`
+	UnsealThreshold int    `json:"unseal_threshold"`
+	RaftRole        string `json:"raft_role"`
+	// Error holds the reason the node could not be inspected
+	Error string `json:"error,omitempty"`
+}
+
+// Healthy returns whether the node was reached and reports unsealed
+func (status *NodeStatus) Healthy() bool {
+	return status.Error == "" && status.Initialized && !status.Sealed
+}
+
+// RaftClusterStatus inspects every node of the vault StatefulSet
+func (conf *VaultConfiguration) RaftClusterStatus(clientset kubernetes.Interface) ([]NodeStatus, error) {
+	cluster, err := conf.DiscoverRaftCluster(clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]NodeStatus, 0, len(cluster.Nodes))
+	for _, node := range cluster.Nodes {
+		statuses = append(statuses, conf.nodeStatus(clientset, cluster, node))
+	}
+	return statuses, nil
+}
+
+// nodeStatus inspects a single node, recording rather than returning errors so
+// that one unreachable node doesn't hide the state of the others
+func (conf *VaultConfiguration) nodeStatus(clientset kubernetes.Interface, cluster *RaftCluster, node string) NodeStatus {
+	status := NodeStatus{Node: node, RaftRole: RaftRoleUnknown}
+
+	pod, err := kubernetesinternal.ReadPodV2(clientset, cluster.Namespace, node)
+	if err != nil {
+		status.Phase = "Missing"
+		status.Error = err.Error()
+		return status
+	}
+	status.PodIP = pod.Status.PodIP
+	status.Phase = string(pod.Status.Phase)
+	if pod.Status.PodIP == "" {
+		status.Error = "pod has no IP"
+		return status
+	}
+
+	vaultClient, err := conf.newVaultOperationsForPod(clientset, pod)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	health, err := nodeHealth(vaultClient, node)
+	if err != nil {
+		status.Error = fmt.Sprintf("error retrieving health: %s", err)
+		return status
+	}
+	status.HealthResponse = HealthResponse{
+		Initialized:                health.Initialized,
+		Sealed:                     health.Sealed,
+		Standby:                    health.Standby,
+		PerformanceStandby:         health.PerformanceStandby,
+		ReplicationPerformanceMode: health.ReplicationPerformanceMode,
+		ReplicationDRMode:          health.ReplicationDRMode,
+		ServerTimeUTC:              int(health.ServerTimeUTC),
+		Version:                    health.Version,
+		ClusterName:                health.ClusterName,
+		ClusterID:                  health.ClusterID,
+	}
+
+	sealStatus, err := vaultClient.SealStatus()
+	if err != nil {
+		status.Error = fmt.Sprintf("error retrieving seal status: %s", err)
+		return status
+	}
+	status.UnsealProgress = sealStatus.Progress
+	status.UnsealThreshold = sealStatus.T
+
+	switch {
+	case !health.Initialized:
+		status.RaftRole = RaftRoleUninitialized
+	case health.Sealed:
+		status.RaftRole = RaftRoleUnknown
+	case health.Standby:
+		status.RaftRole = RaftRoleFollower
+	default:
+		status.RaftRole = RaftRoleLeader
+	}
+	return status
+}
+
+// WriteNodeStatus renders node statuses as a table, json, or yaml
+func WriteNodeStatus(w io.Writer, statuses []NodeStatus, format string) error {
+	switch format {
+	case OutputTable:
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "NODE\tPOD IP\tPHASE\tINITIALIZED\tSEALED\tSTANDBY\tUNSEAL PROGRESS\tRAFT ROLE\tVERSION\tCLUSTER NAME\tCLUSTER ID\tERROR")
+		for _, status := range statuses {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%v\t%v\t%v\t%v/%v\t%s\t%s\t%s\t%s\t%s\n",
+				status.Node,
+				status.PodIP,
+				status.Phase,
+				status.Initialized,
+				status.Sealed,
+				status.Standby,
+				status.UnsealProgress,
+				status.UnsealThreshold,
+				status.RaftRole,
+				status.Version,
+				status.ClusterName,
+				status.ClusterID,
+				status.Error,
+			)
+		}
+		return table.Flush()
+	case OutputJSON:
+		content, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(content))
+		return err
+	case OutputYAML:
+		content, err := yaml.Marshal(statuses)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	default:
+		return fmt.Errorf("unsupported output %q, must be one of %s, %s or %s", format, OutputTable, OutputJSON, OutputYAML)
+	}
+}
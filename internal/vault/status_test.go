@@ -0,0 +1,69 @@
+package vault
+
+import (
+	"testing"
+)
+
+func TestNodeStatusHealthy(t *testing.T) {
+	tests := []struct {
+		name   string
+		status NodeStatus
+		want   bool
+	}{
+		{
+			name:   "An initialized and unsealed node should be healthy",
+			status: NodeStatus{HealthResponse: HealthResponse{Initialized: true}},
+			want:   true,
+		},
+		{
+			name:   "A sealed node should be unhealthy",
+			status: NodeStatus{HealthResponse: HealthResponse{Initialized: true, Sealed: true}},
+		},
+		{
+			name:   "An uninitialized node should be unhealthy",
+			status: NodeStatus{},
+		},
+		{
+			name:   "An unreachable node should be unhealthy whatever it last reported",
+			status: NodeStatus{HealthResponse: HealthResponse{Initialized: true}, Error: "error retrieving health: connection refused"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.status.Healthy(); got != tt.want {
+				t.Errorf("Healthy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRaftClusterStatus(t *testing.T) {
+	keys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+	leader := &vaultStub{initialized: true, keys: keys, threshold: 2}
+	sealed := &vaultStub{initialized: true, sealed: true, keys: keys, threshold: 2, progress: 1}
+	uninitialized := &vaultStub{sealed: true}
+	clientset, conf := newTestCluster(t, leader, sealed, uninitialized)
+
+	statuses, err := conf.RaftClusterStatus(clientset)
+	if err != nil {
+		t.Fatalf("RaftClusterStatus() error = %v", err)
+	}
+
+	want := []struct {
+		role     string
+		healthy  bool
+		progress int
+	}{
+		{role: RaftRoleLeader, healthy: true},
+		{role: RaftRoleUnknown, progress: 1},
+		{role: RaftRoleUninitialized},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("RaftClusterStatus() returned %v statuses, want %v", len(statuses), len(want))
+	}
+	for i, status := range statuses {
+		if status.RaftRole != want[i].role || status.Healthy() != want[i].healthy || status.UnsealProgress != want[i].progress {
+			t.Errorf("%s role = %s, healthy = %v, unseal progress = %v, want %s, %v and %v", status.Node, status.RaftRole, status.Healthy(), status.UnsealProgress, want[i].role, want[i].healthy, want[i].progress)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package vault
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	"github.com/spf13/afero"
+	"k8s.io/client-go/kubernetes"
+)
+
+// tlsMaterial holds the PEM encoded certificates used to reach vault listeners
+type tlsMaterial struct {
+	CACert     string
+	ClientCert string
+	ClientKey  string
+}
+
+// scheme returns the scheme of the vault api listeners
+func (conf *VaultConfiguration) scheme() string {
+	if conf.TLS.Enabled {
+		return "https"
+	}
+	return "http"
+}
+
+// loadTLSMaterial reads the CA bundle and client certificate from the
+// configured Secret and files - files take precedence over the Secret
+func (conf *VaultConfiguration) loadTLSMaterial(clientset kubernetes.Interface) (*tlsMaterial, error) {
+	if conf.tls != nil || !conf.TLS.Enabled {
+		return conf.tls, nil
+	}
+
+	material := &tlsMaterial{}
+	if conf.TLS.Secret != "" {
+		if clientset == nil {
+			return nil, fmt.Errorf("a kubernetes client is required to read TLS Secret %s", conf.TLS.Secret)
+		}
+		secret, err := kubernetesinternal.GetSecretV2(clientset, conf.Namespace, conf.TLS.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("error reading TLS Secret %s in Namespace %s: %s", conf.TLS.Secret, conf.Namespace, err)
+		}
+		if len(secret.Data[TLSSecretCAKey]) == 0 {
+			return nil, fmt.Errorf("TLS Secret %s in Namespace %s has no %s", conf.TLS.Secret, conf.Namespace, TLSSecretCAKey)
+		}
+		material.CACert = string(secret.Data[TLSSecretCAKey])
+		material.ClientCert = string(secret.Data[TLSSecretCertKey])
+		material.ClientKey = string(secret.Data[TLSSecretKeyKey])
+	}
+
+	for _, file := range []struct {
+		path   string
+		target *string
+	}{
+		{conf.TLS.CACertFile, &material.CACert},
+		{conf.TLS.ClientCertFile, &material.ClientCert},
+		{conf.TLS.ClientKeyFile, &material.ClientKey},
+	} {
+		if file.path == "" {
+			continue
+		}
+		content, err := afero.ReadFile(fs, file.path)
+		if err != nil {
+			return nil, err
+		}
+		*file.target = string(content)
+	}
+
+	if (material.ClientCert == "") != (material.ClientKey == "") {
+		return nil, fmt.Errorf("a TLS client certificate and key must be supplied together")
+	}
+
+	conf.tls = material
+	return material, nil
+}
+
+// newVaultClient returns a vault api client for the supplied address using the
+// configured TLS material
+func (conf *VaultConfiguration) newVaultClient(clientset kubernetes.Interface, address string) (*vaultapi.Client, error) {
+	config, err := conf.vaultAPIConfig(clientset, address)
+	if err != nil {
+		return nil, err
+	}
+
+	newClient := conf.NewClient
+	if newClient == nil {
+		newClient = vaultapi.NewClient
+	}
+	return newClient(config)
+}
+
+// vaultAPIConfig returns the vault api configuration for the supplied address
+// using the configured TLS material
+func (conf *VaultConfiguration) vaultAPIConfig(clientset kubernetes.Interface, address string) (*vaultapi.Config, error) {
+	config := vaultapi.DefaultConfig()
+	config.Address = address
+
+	if conf.TLS.Enabled {
+		material, err := conf.loadTLSMaterial(clientset)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig := &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ServerName:         conf.TLS.ServerName,
+			InsecureSkipVerify: conf.TLS.SkipVerify,
+		}
+		if material.CACert != "" {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM([]byte(material.CACert)) {
+				return nil, fmt.Errorf("no certificates could be parsed from the TLS CA bundle")
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if material.ClientCert != "" {
+			certificate, err := tls.X509KeyPair([]byte(material.ClientCert), []byte(material.ClientKey))
+			if err != nil {
+				return nil, fmt.Errorf("error parsing TLS client certificate: %s", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{certificate}
+		}
+		config.HttpClient.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+	}
+
+	return config, nil
+}
+
+// raftJoinRequest returns a raft join request for the cluster leader carrying
+// the TLS material followers need to reach it
+func (conf *VaultConfiguration) raftJoinRequest(clientset kubernetes.Interface, cluster *RaftCluster) (*vaultapi.RaftJoinRequest, error) {
+	request := &vaultapi.RaftJoinRequest{
+		LeaderAPIAddr: conf.RaftLeaderAddress,
+		Retry:         true,
+	}
+	if request.LeaderAPIAddr == "" {
+		request.LeaderAPIAddr = cluster.LeaderAPIAddress(conf.scheme(), conf.Port)
+	}
+
+	material, err := conf.loadTLSMaterial(clientset)
+	if err != nil {
+		return nil, err
+	}
+	if material != nil {
+		request.LeaderCACert = material.CACert
+		request.LeaderClientCert = material.ClientCert
+		request.LeaderClientKey = material.ClientKey
+	}
+
+	return request, nil
+}
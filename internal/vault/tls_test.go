@@ -0,0 +1,183 @@
+package vault
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// selfSignedPEM returns a PEM encoded self-signed certificate and its key
+func selfSignedPEM(t *testing.T, commonName string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestVaultAPIConfig(t *testing.T) {
+	appFS := afero.NewMemMapFs()
+	originalFS := fs
+	fs = appFS
+	t.Cleanup(func() { fs = originalFS })
+	caCert, _ := selfSignedPEM(t, "vault-ca")
+	clientCert, clientKey := selfSignedPEM(t, "vault-handler")
+	for path, content := range map[string]string{"/tls/ca.crt": caCert, "/tls/client.crt": clientCert, "/tls/client.key": clientKey, "/tls/garbage.crt": "not a certificate"} {
+		if err := afero.WriteFile(appFS, path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name            string
+		tls             TLSOptions
+		wantRootCAs     bool
+		wantClientCerts int
+		wantErr         string
+	}{
+		{
+			name: "TLS settings should be left alone when TLS is disabled",
+			tls:  TLSOptions{CACertFile: "/tls/ca.crt", ServerName: "vault.example.com"},
+		},
+		{
+			name:        "The CA, server name and verification setting should be applied",
+			tls:         TLSOptions{Enabled: true, CACertFile: "/tls/ca.crt", ServerName: "vault.example.com", SkipVerify: true},
+			wantRootCAs: true,
+		},
+		{
+			name:            "A client certificate should be presented when supplied",
+			tls:             TLSOptions{Enabled: true, CACertFile: "/tls/ca.crt", ClientCertFile: "/tls/client.crt", ClientKeyFile: "/tls/client.key"},
+			wantRootCAs:     true,
+			wantClientCerts: 1,
+		},
+		{
+			name:    "A CA bundle without certificates should be rejected",
+			tls:     TLSOptions{Enabled: true, CACertFile: "/tls/garbage.crt"},
+			wantErr: "no certificates could be parsed",
+		},
+		{
+			name:    "A client certificate without its key should be rejected",
+			tls:     TLSOptions{Enabled: true, ClientCertFile: "/tls/client.crt"},
+			wantErr: "must be supplied together",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &VaultConfiguration{Namespace: "vault", TLS: tt.tls}
+			config, err := conf.vaultAPIConfig(nil, "https://10.0.0.1:8200")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("vaultAPIConfig() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("vaultAPIConfig() error = %v", err)
+			}
+
+			tlsConfig := config.HttpClient.Transport.(*http.Transport).TLSClientConfig
+			if !tt.tls.Enabled {
+				if tlsConfig != nil && (tlsConfig.ServerName != "" || tlsConfig.RootCAs != nil) {
+					t.Errorf("TLS config = %+v, want the defaults with TLS disabled", tlsConfig)
+				}
+				return
+			}
+			if tlsConfig.ServerName != tt.tls.ServerName || tlsConfig.InsecureSkipVerify != tt.tls.SkipVerify {
+				t.Errorf("TLS server name = %q and skip verify = %v, want %q and %v", tlsConfig.ServerName, tlsConfig.InsecureSkipVerify, tt.tls.ServerName, tt.tls.SkipVerify)
+			}
+			if (tlsConfig.RootCAs != nil) != tt.wantRootCAs {
+				t.Errorf("TLS root CAs set = %v, want %v", tlsConfig.RootCAs != nil, tt.wantRootCAs)
+			}
+			if len(tlsConfig.Certificates) != tt.wantClientCerts {
+				t.Errorf("TLS config presents %v client certificates, want %v", len(tlsConfig.Certificates), tt.wantClientCerts)
+			}
+		})
+	}
+}
+
+func TestRaftJoinRequest(t *testing.T) {
+	appFS := afero.NewMemMapFs()
+	originalFS := fs
+	fs = appFS
+	t.Cleanup(func() { fs = originalFS })
+	secretCA, _ := selfSignedPEM(t, "secret-ca")
+	fileCA, _ := selfSignedPEM(t, "file-ca")
+	if err := afero.WriteFile(appFS, "/tls/ca.crt", []byte(fileCA), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault-tls", Namespace: "vault"},
+		Data:       map[string][]byte{TLSSecretCAKey: []byte(secretCA)},
+	}
+	cluster := &RaftCluster{Namespace: "vault", ServiceName: "vault-internal", Nodes: []string{"vault-0", "vault-1"}}
+
+	tests := []struct {
+		name       string
+		tls        TLSOptions
+		wantAddr   string
+		wantCACert string
+	}{
+		{
+			name:     "Without TLS followers should join over http without a CA",
+			wantAddr: "http://vault-0.vault-internal:8200",
+		},
+		{
+			name:       "The CA from the TLS Secret should be passed to followers",
+			tls:        TLSOptions{Enabled: true, Secret: "vault-tls"},
+			wantAddr:   "https://vault-0.vault-internal:8200",
+			wantCACert: secretCA,
+		},
+		{
+			name:       "A CA file should take precedence over the TLS Secret",
+			tls:        TLSOptions{Enabled: true, Secret: "vault-tls", CACertFile: "/tls/ca.crt"},
+			wantAddr:   "https://vault-0.vault-internal:8200",
+			wantCACert: fileCA,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &VaultConfiguration{Namespace: "vault", Port: VaultPort, TLS: tt.tls}
+			request, err := conf.raftJoinRequest(fake.NewSimpleClientset(secret), cluster)
+			if err != nil {
+				t.Fatalf("raftJoinRequest() error = %v", err)
+			}
+			if request.LeaderAPIAddr != tt.wantAddr || !request.Retry {
+				t.Errorf("raftJoinRequest() leader = %q with retry %v, want %q with retry", request.LeaderAPIAddr, request.Retry, tt.wantAddr)
+			}
+			if request.LeaderCACert != tt.wantCACert {
+				t.Errorf("raftJoinRequest() leader CA = %q, want %q", request.LeaderCACert, tt.wantCACert)
+			}
+		})
+	}
+}
@@ -1,137 +1,254 @@
 package vault
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"time"
 
 	vaultapi "github.com/hashicorp/vault/api"
 	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	"github.com/kubefirst/vault-handler/internal/logging"
 	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
-// UnsealCoreTransit initializes and unseals a core instance used to provide transit unseal
-func (conf *VaultConfiguration) UnsealCoreTransit(clientset *kubernetes.Clientset, restConfig *rest.Config) error {
-	//* vault port-forward
-	log.Infof("starting port-forward for vault-0")
-	vaultStopChannel := make(chan struct{}, 1)
-	defer func() {
-		close(vaultStopChannel)
-	}()
+// UnsealCoreTransit initializes and unseals a core instance used to provide
+// transit unseal, keeping its initialization data in the core key store
+func (conf *VaultConfiguration) UnsealCoreTransit(clientset kubernetes.Interface, restConfig *rest.Config, opts *VaultTransitExecutionOptions) error {
+	keyStore, err := conf.coreKeyStore(clientset, opts)
+	if err != nil {
+		return err
+	}
 
 	// Vault api client
-	vaultClient, err := vaultapi.NewClient(&conf.Config)
+	coreClient, pod, node, err := conf.coreVaultClient(clientset, opts)
 	if err != nil {
 		return err
 	}
+	logger := log.WithFields(log.Fields{"node": node, "phase": "init"})
+	if pod != nil {
+		logger = log.WithFields(podFields(pod, "init"))
+	}
 
-	vaultClient.CloneConfig().ConfigureTLS(&vaultapi.TLSConfig{
-		Insecure: true,
-	})
+	return conf.initOrUnsealNode(coreClient.Sys(), keyStore, pod, node, logger)
+}
+
+// coreKeyStore returns the KeyStore holding the core instance's
+// initialization data, which is kept apart from the main cluster's so that
+// neither overwrites the other
+func (conf *VaultConfiguration) coreKeyStore(clientset kubernetes.Interface, opts *VaultTransitExecutionOptions) (KeyStore, error) {
+	keyStoreOpts := conf.KeyStore
+	keyStoreOpts.SecretName = opts.CoreKeyStoreSecretName
+	keyStoreOpts.Path = opts.CoreKeyStorePath
+	if keyStoreOpts.Namespace == "" {
+		keyStoreOpts.Namespace = conf.Namespace
+	}
+	return NewKeyStore(clientset, &keyStoreOpts)
+}
+
+// coreVaultClient returns a client for the core instance along with its Pod
+// and the name it is logged as - the instance is reached at --address, with no
+// Pod, unless port-forwarding is enabled, in which case the first replica of
+// the core StatefulSet is forwarded to
+func (conf *VaultConfiguration) coreVaultClient(clientset kubernetes.Interface, opts *VaultTransitExecutionOptions) (*vaultapi.Client, *v1.Pod, string, error) {
+	if !conf.portForwardEnabled() {
+		vaultClient, err := conf.newVaultClient(clientset, conf.Config.Address)
+		return vaultClient, nil, conf.Config.Address, err
+	}
+
+	cluster, err := conf.discoverRaftCluster(clientset, opts.CoreStatefulSetName)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	node := cluster.Leader()
+	pod, err := conf.nodePod(context.Background(), clientset, cluster.Namespace, node)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	vaultClient, err := conf.newVaultClientForPod(clientset, pod)
 
-	// Determine vault health
-	health, err := vaultClient.Sys().Health()
+	return vaultClient, pod, node, err
+}
+
+// BootstrapTransit initializes and unseals a core instance, then configures it
+// to provide transit auto unseal for the main vault cluster
+//
+// A transit engine and key, a policy scoped to encrypt and decrypt with that
+// key, and a periodic token holding the policy are created on the core
+// instance, and the token is written to a Secret that the main cluster's
+// seal "transit" stanza reads - every step is skipped when already done
+func (conf *VaultConfiguration) BootstrapTransit(clientset kubernetes.Interface, restConfig *rest.Config, opts *VaultTransitExecutionOptions) error {
+	err := conf.UnsealCoreTransit(clientset, restConfig, opts)
+	if err != nil {
+		return err
+	}
+	logger := log.WithField("phase", "transit")
+
+	keyStore, err := conf.coreKeyStore(clientset, opts)
 	if err != nil {
 		return err
 	}
+	var token string
+	data, err := keyStore.Load()
+	if err == nil {
+		token, err = conf.token(data)
+	}
+	if err != nil {
+		if !conf.DryRun {
+			return err
+		}
+		// The core instance can't be inspected until it has been initialized
+		logger.Warnf("planning transit bootstrap without checking the core instance: %s", err)
+		conf.plan("enable transit engine at %s", opts.Mount)
+		conf.plan("create transit key %s", opts.KeyName)
+		conf.plan("write policy %s", opts.PolicyName)
+		conf.plan("create a periodic transit unseal token and write it to Secret %s in Namespace %s", opts.SecretName, opts.SecretNamespace)
+		return nil
+	}
 
-	switch health.Initialized {
-	case false:
-		log.Info("initializing vault raft leader")
+	vaultClient, _, _, err := conf.coreVaultClient(clientset, opts)
+	if err != nil {
+		return err
+	}
+	vaultClient.SetToken(token)
 
-		initResponse, err := vaultClient.Sys().Init(&vaultapi.InitRequest{
-			RecoveryShares:    RecoveryShares,
-			RecoveryThreshold: RecoveryThreshold,
-			SecretShares:      SecretShares,
-			SecretThreshold:   SecretThreshold,
-		})
+	// Transit engine
+	mounts, err := vaultClient.Sys().ListMounts()
+	if err != nil {
+		return err
+	}
+	if _, ok := mounts[opts.Mount+"/"]; ok {
+		logger.Infof("transit engine is already enabled at %s", opts.Mount)
+	} else if !conf.plan("enable transit engine at %s", opts.Mount) {
+		logger.Infof("enabling transit engine at %s", opts.Mount)
+		err = vaultClient.Sys().Mount(opts.Mount, &vaultapi.MountInput{Type: "transit"})
 		if err != nil {
 			return err
 		}
+	}
 
-		// Write secret containing init data
-		dataToWrite := make(map[string][]byte)
-		dataToWrite["root-token"] = []byte(initResponse.RootToken)
-		for i, value := range initResponse.Keys {
-			dataToWrite[fmt.Sprintf("root-unseal-key-%v", i+1)] = []byte(value)
-		}
-		secret := v1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      VaultSecretName,
-				Namespace: VaultNamespace,
-			},
-			Data: dataToWrite,
+	// Transit key
+	keyPath := fmt.Sprintf("%s/keys/%s", opts.Mount, opts.KeyName)
+	existingKey, err := vaultClient.Logical().Read(keyPath)
+	if err != nil {
+		return err
+	}
+	if existingKey != nil {
+		logger.Infof("transit key %s already exists", opts.KeyName)
+	} else if !conf.plan("create transit key %s", opts.KeyName) {
+		logger.Infof("creating transit key %s", opts.KeyName)
+		_, err = vaultClient.Logical().Write(keyPath, map[string]interface{}{})
+		if err != nil {
+			return err
 		}
+	}
 
-		log.Infof("creating secret %s containing vault initialization data", VaultSecretName)
-		err = kubernetesinternal.CreateSecretV2(clientset, &secret)
+	// Policy
+	policy := transitUnsealPolicy(opts.Mount, opts.KeyName)
+	existingPolicy, err := vaultClient.Sys().GetPolicy(opts.PolicyName)
+	if err != nil {
+		return err
+	}
+	if existingPolicy == policy {
+		logger.Infof("policy %s is already up to date", opts.PolicyName)
+	} else if !conf.plan("write policy %s", opts.PolicyName) {
+		logger.Infof("writing policy %s", opts.PolicyName)
+		err = vaultClient.Sys().PutPolicy(opts.PolicyName, policy)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		time.Sleep(time.Second * 3)
-
-		// Unseal raft leader
-		for i, shard := range initResponse.Keys {
-			if i < 3 {
-				log.Infof("passing unseal shard %v to %s", i+1, "vault-0")
-				_, err := vaultClient.Sys().Unseal(shard)
-				if err != nil {
-					return err
-				}
-			} else {
-				break
-			}
+	}
+
+	// Periodic token, reused when the Secret already holds a valid one
+	var existingToken string
+	existingSecret, err := kubernetesinternal.GetSecretV2(clientset, opts.SecretNamespace, opts.SecretName)
+	secretExists := err == nil
+	switch {
+	case secretExists:
+		existingToken = string(existingSecret.Data[opts.SecretKey])
+	case !apierrors.IsNotFound(err):
+		return fmt.Errorf("error reading Secret %s in Namespace %s: %s", opts.SecretName, opts.SecretNamespace, err)
+	}
+	if existingToken != "" {
+		logging.Redact(existingToken)
+		if tokenLookup, err := vaultClient.Auth().Token().Lookup(existingToken); err == nil && transitTokenUsable(tokenLookup, opts.PolicyName) {
+			logger.Infof("Secret %s already holds a valid transit unseal token", opts.SecretName)
+			return nil
 		}
-	case true:
-		log.Infof("%s is already initialized", "vault-0")
+		logger.Warnf("Secret %s holds a token that can no longer be used, creating a new one", opts.SecretName)
+	}
 
-		// Determine vault health
-		health, err = vaultClient.Sys().Health()
-		if err != nil {
-			return err
+	if conf.plan("create a periodic transit unseal token and write it to Secret %s in Namespace %s", opts.SecretName, opts.SecretNamespace) {
+		return nil
+	}
+	logger.Infof("creating periodic transit unseal token with policy %s", opts.PolicyName)
+	tokenSecret, err := vaultClient.Auth().Token().CreateOrphan(&vaultapi.TokenCreateRequest{
+		Policies:    []string{opts.PolicyName},
+		Period:      opts.TokenPeriod.String(),
+		DisplayName: "transit-unseal",
+		NoParent:    true,
+	})
+	if err != nil {
+		return err
+	}
+	if tokenSecret == nil || tokenSecret.Auth == nil {
+		return fmt.Errorf("token create response is empty")
+	}
+	logging.Redact(tokenSecret.Auth.ClientToken)
+
+	if secretExists {
+		// Only the token is replaced, keeping any other keys, labels and
+		// annotations of the Secret
+		secret := existingSecret.DeepCopy()
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
 		}
+		secret.Data[opts.SecretKey] = []byte(tokenSecret.Auth.ClientToken)
+		return kubernetesinternal.UpdateSecretV2(clientset, secret)
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opts.SecretName,
+			Namespace: opts.SecretNamespace,
+		},
+		Data: map[string][]byte{
+			opts.SecretKey: []byte(tokenSecret.Auth.ClientToken),
+		},
+	}
+	return kubernetesinternal.CreateSecretV2(clientset, secret)
+}
 
-		switch health.Sealed {
-		case true:
-			existingInitResponse, err := parseExistingVaultInitSecret(clientset)
-			if err != nil {
-				return err
-			}
-
-			// Unseal raft leader
-			for i, shard := range existingInitResponse.Keys {
-				if i < 3 {
-					retries := 10
-					for r := 0; r < retries; r++ {
-						if r > 0 {
-							log.Warnf("encountered an error during unseal, retrying (%d/%d)", r+1, retries)
-						}
-						time.Sleep(5 * time.Second)
-
-						log.Infof("passing unseal shard %v to %s", i+1, "vault-0")
-						_, err := vaultClient.Sys().Unseal(shard)
-						if err != nil {
-							continue
-						} else {
-							break
-						}
-					}
-					time.Sleep(time.Second * 2)
-				} else {
-					break
-				}
-
-			}
-		case false:
-			log.Infof("%s is already unsealed", "vault-0")
+// transitTokenUsable returns whether a token lookup describes a periodic token
+// holding the transit unseal policy, rather than any token that still resolves
+func transitTokenUsable(tokenLookup *vaultapi.Secret, policyName string) bool {
+	if tokenLookup == nil || tokenLookup.Data == nil {
+		return false
+	}
+	policies, _ := tokenLookup.Data["policies"].([]interface{})
+	hasPolicy := false
+	for _, policy := range policies {
+		if policy == policyName {
+			hasPolicy = true
 		}
 	}
+	period, _ := tokenLookup.Data["period"].(json.Number)
+	seconds, _ := period.Int64()
+	return hasPolicy && seconds > 0
+}
 
-	log.Infof("closing port-forward for vault-0")
-	time.Sleep(time.Second * 3)
+// transitUnsealPolicy returns a policy allowing only encryption and decryption
+// with the transit unseal key
+func transitUnsealPolicy(mount string, keyName string) string {
+	return fmt.Sprintf(`path "%[1]s/encrypt/%[2]s" {
+  capabilities = ["update"]
+}
 
-	return nil
+path "%[1]s/decrypt/%[2]s" {
+  capabilities = ["update"]
+}
+`, mount, keyName)
 }
@@ -0,0 +1,267 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func init() {
+	stubRoutes["/v1/auth/token/create-orphan"] = (*vaultStub).serveTokenCreate
+	stubRoutes["/v1/auth/token/lookup"] = (*vaultStub).serveTokenLookup
+}
+
+// serveTokenCreate issues an orphan token, keeping its policies and period in
+// the logical store so that it can be looked up
+func (stub *vaultStub) serveTokenCreate(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	if stub.logical == nil {
+		stub.logical = make(map[string]map[string]interface{})
+	}
+	stub.writes = append(stub.writes, stubWrite{path: "auth/token/create-orphan", data: body})
+	token := fmt.Sprintf("hvs.stubtoken%v", len(stub.writes))
+	period, _ := time.ParseDuration(fmt.Sprint(body["period"]))
+	stub.logical["auth/token/tokens/"+token] = map[string]interface{}{
+		"id":       token,
+		"policies": body["policies"],
+		"period":   int(period.Seconds()),
+	}
+	writeStubJSON(w, map[string]interface{}{"auth": map[string]interface{}{"client_token": token, "policies": body["policies"]}})
+}
+
+// serveTokenLookup reports the policies and period of a token issued by serveTokenCreate
+func (stub *vaultStub) serveTokenLookup(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	data, ok := stub.logical[fmt.Sprintf("auth/token/tokens/%v", body["token"])]
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		writeStubJSON(w, map[string]interface{}{"errors": []string{"bad token"}})
+		return
+	}
+	writeStubJSON(w, map[string]interface{}{"data": data})
+}
+
+// transitOptions returns the default transit options for the core instance
+func transitOptions() *VaultTransitExecutionOptions {
+	return &VaultTransitExecutionOptions{
+		Mount:                  TransitMount,
+		KeyName:                TransitKeyName,
+		PolicyName:             TransitPolicyName,
+		TokenPeriod:            TransitTokenPeriod,
+		SecretName:             TransitTokenSecretName,
+		SecretNamespace:        "vault",
+		SecretKey:              TransitTokenSecretKey,
+		CoreStatefulSetName:    TransitCoreStatefulSetName,
+		CoreKeyStoreSecretName: TransitCoreKeyStoreSecretName,
+	}
+}
+
+func TestUnsealCoreTransit(t *testing.T) {
+	mainKeys := []string{"a1a1a1a1", "b2b2b2b2", "c3c3c3c3"}
+	coreKeys := []string{"d4d4d4d4", "e5e5e5e5", "f6f6f6f6"}
+
+	tests := []struct {
+		name       string
+		core       *vaultStub
+		coreStored bool
+		wantInits  int
+	}{
+		{
+			name:      "An uninitialized core instance should be persisted apart from the main cluster",
+			core:      &vaultStub{sealed: true},
+			wantInits: 1,
+		},
+		{
+			name:       "A sealed core instance should be unsealed with its own stored keys",
+			core:       &vaultStub{initialized: true, sealed: true, keys: coreKeys, threshold: 2},
+			coreStored: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset, conf := newTestCluster(t, tt.core)
+			storeInitData(t, clientset, conf, mainKeys, 2)
+			conf.Config = &vaultapi.Config{Address: "http://10.0.0.1:8200"}
+			opts := transitOptions()
+			coreKeyStore, err := conf.coreKeyStore(clientset, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.coreStored {
+				data := &InitData{Version: InitDataVersion, SecretShares: 3, SecretThreshold: 2}
+				data.Keys = coreKeys
+				if err := coreKeyStore.Save(data); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := conf.UnsealCoreTransit(clientset, nil, opts); err != nil {
+				t.Fatalf("UnsealCoreTransit() error = %v", err)
+			}
+
+			if !tt.core.initialized || tt.core.sealed || tt.core.inits != tt.wantInits {
+				t.Errorf("core initialized = %v, sealed = %v after %v init requests, want initialized and unsealed after %v", tt.core.initialized, tt.core.sealed, tt.core.inits, tt.wantInits)
+			}
+			coreData, err := coreKeyStore.Load()
+			if err != nil {
+				t.Fatalf("core initialization data wasn't persisted: %v", err)
+			}
+			if !reflect.DeepEqual(coreData.Keys, tt.core.keys) {
+				t.Errorf("core persisted keys = %v, want %v", coreData.Keys, tt.core.keys)
+			}
+			mainKeyStore, _ := conf.keyStore(clientset)
+			mainData, err := mainKeyStore.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(mainData.Keys, mainKeys) {
+				t.Errorf("main cluster keys = %v, want them left untouched as %v", mainData.Keys, mainKeys)
+			}
+		})
+	}
+}
+
+func TestBootstrapTransit(t *testing.T) {
+	keys := []string{"d4d4d4d4", "e5e5e5e5", "f6f6f6f6"}
+	opts := transitOptions()
+	policy := transitUnsealPolicy(opts.Mount, opts.KeyName)
+	period := int(opts.TokenPeriod.Seconds())
+
+	tests := []struct {
+		name             string
+		policy           string
+		tokenPolicies    []interface{}
+		tokenPeriod      int
+		secretExists     bool
+		wantPolicyWrites int
+		wantTokens       int
+	}{
+		{
+			name:             "A fresh core instance should get a policy and a token",
+			wantPolicyWrites: 1,
+			wantTokens:       1,
+		},
+		{
+			name:          "A rerun with the same policy and a valid token should write nothing",
+			policy:        policy,
+			tokenPolicies: []interface{}{"default", opts.PolicyName},
+			tokenPeriod:   period,
+			secretExists:  true,
+		},
+		{
+			name:             "A policy that differs should be rewritten",
+			policy:           `path "transit/*" { capabilities = ["read"] }`,
+			tokenPolicies:    []interface{}{opts.PolicyName},
+			tokenPeriod:      period,
+			secretExists:     true,
+			wantPolicyWrites: 1,
+		},
+		{
+			name:          "A token without the transit policy should be replaced",
+			policy:        policy,
+			tokenPolicies: []interface{}{"default"},
+			tokenPeriod:   period,
+			secretExists:  true,
+			wantTokens:    1,
+		},
+		{
+			name:          "A token without a period should be replaced",
+			policy:        policy,
+			tokenPolicies: []interface{}{opts.PolicyName},
+			secretExists:  true,
+			wantTokens:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core := &vaultStub{initialized: true, keys: keys, threshold: 2, logical: map[string]map[string]interface{}{}}
+			clientset, conf := newTestCluster(t, core)
+			conf.Config = &vaultapi.Config{Address: "http://10.0.0.1:8200"}
+			coreKeyStore, err := conf.coreKeyStore(clientset, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := &InitData{Version: InitDataVersion, InitResponse: vaultapi.InitResponse{Keys: keys, RootToken: "hvs.stubroottoken"}, SecretShares: 3, SecretThreshold: 2}
+			if err := coreKeyStore.Save(data); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.policy != "" {
+				core.logical["sys/mounts/"+opts.Mount] = map[string]interface{}{"type": "transit"}
+				core.logical[fmt.Sprintf("%s/keys/%s", opts.Mount, opts.KeyName)] = map[string]interface{}{"name": opts.KeyName}
+				core.logical["sys/policies/acl/"+opts.PolicyName] = map[string]interface{}{"policy": tt.policy}
+			}
+			existingToken := "hvs.existingtoken"
+			if tt.tokenPolicies != nil {
+				core.logical["auth/token/tokens/"+existingToken] = map[string]interface{}{
+					"id":       existingToken,
+					"policies": tt.tokenPolicies,
+					"period":   tt.tokenPeriod,
+				}
+			}
+			existingSecret := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        opts.SecretName,
+					Namespace:   opts.SecretNamespace,
+					Labels:      map[string]string{"app.kubernetes.io/part-of": "vault"},
+					Annotations: map[string]string{"reloader.stakater.com/match": "true"},
+				},
+				Data: map[string][]byte{
+					opts.SecretKey: []byte(existingToken),
+					"ca.crt":       []byte("ca"),
+				},
+			}
+			if tt.secretExists {
+				if _, err := clientset.CoreV1().Secrets(opts.SecretNamespace).Create(context.Background(), existingSecret, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := conf.BootstrapTransit(clientset, nil, opts); err != nil {
+				t.Fatalf("BootstrapTransit() error = %v", err)
+			}
+
+			policyWrites, tokens := 0, 0
+			for _, write := range core.writes {
+				switch write.path {
+				case "sys/policies/acl/" + opts.PolicyName:
+					policyWrites++
+				case "auth/token/create-orphan":
+					tokens++
+				}
+			}
+			if policyWrites != tt.wantPolicyWrites {
+				t.Errorf("policy was written %v times, want %v", policyWrites, tt.wantPolicyWrites)
+			}
+			if tokens != tt.wantTokens {
+				t.Errorf("%v tokens were created, want %v", tokens, tt.wantTokens)
+			}
+			if got := core.logical["sys/policies/acl/"+opts.PolicyName]["policy"]; got != policy {
+				t.Errorf("policy = %q, want %q", got, policy)
+			}
+
+			secret, err := clientset.CoreV1().Secrets(opts.SecretNamespace).Get(context.Background(), opts.SecretName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("transit token Secret wasn't written: %v", err)
+			}
+			token := string(secret.Data[opts.SecretKey])
+			if tt.wantTokens == 0 && token != existingToken {
+				t.Errorf("Secret token = %q, want the valid token %q kept", token, existingToken)
+			}
+			if tt.wantTokens > 0 && token == existingToken {
+				t.Errorf("Secret token = %q, want it replaced", token)
+			}
+			if !tt.secretExists {
+				return
+			}
+			if string(secret.Data["ca.crt"]) != "ca" || !reflect.DeepEqual(secret.Labels, existingSecret.Labels) || !reflect.DeepEqual(secret.Annotations, existingSecret.Annotations) {
+				t.Errorf("Secret data = %v, labels = %v and annotations = %v, want the other keys, labels and annotations kept", secret.Data, secret.Labels, secret.Annotations)
+			}
+		})
+	}
+}
@@ -1,6 +1,13 @@
 package vault
 
-import vaultapi "github.com/hashicorp/vault/api"
+import (
+	"sync"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
+	"k8s.io/client-go/rest"
+)
 
 // HealthResponse specifies the content of a health response from a vault API
 // https://developer.hashicorp.com/vault/api-docs/system/health#sample-response
@@ -39,6 +46,22 @@ type RaftJoinRequest struct {
 type RaftJoinResponse struct {
 }
 
+// RaftConfiguration specifies the content of a raft configuration response from a vault API
+// https://developer.hashicorp.com/vault/api-docs/system/storage/raft#read-raft-configuration
+type RaftConfiguration struct {
+	Servers []RaftServer `json:"servers"`
+	Index   int          `json:"index"`
+}
+
+// RaftServer specifies a single raft peer within a raft configuration
+type RaftServer struct {
+	NodeID          string `json:"node_id"`
+	Address         string `json:"address"`
+	Leader          bool   `json:"leader"`
+	ProtocolVersion string `json:"protocol_version"`
+	Voter           bool   `json:"voter"`
+}
+
 // UnsealRequest specifies the content of an `unseal` operation against a vault API
 // https://developer.hashicorp.com/vault/api-docs/system/unseal#sample-payload
 type UnsealRequest struct {
@@ -69,7 +92,91 @@ type VaultUnsealOptions struct {
 }
 
 type VaultConfiguration struct {
-	Config vaultapi.Config
+	Config *vaultapi.Config
+	// Namespace holds the Namespace that the vault StatefulSet runs in
+	Namespace string
+	// StatefulSetName holds the name of the StatefulSet that runs vault
+	StatefulSetName string
+	// KeyStore selects where initialization data is persisted
+	KeyStore KeyStoreOptions
+	// UnsealKeysFile holds custodian decrypted unseal shares, one per line
+	UnsealKeysFile string
+	// TLS configures how vault listeners are reached
+	TLS TLSOptions
+	// Retry controls how unseal shares are submitted
+	Retry RetryPolicy
+	// Token authenticates requests that require it, defaulting to VAULT_TOKEN
+	// and then the stored root token
+	Token string
+	// Port is the port the vault API listens on in every Pod
+	Port int
+	// RaftLeaderAddress overrides the address raft followers join the leader
+	// at, which defaults to the leader Pod behind the StatefulSet's Service
+	RaftLeaderAddress string
+	// SecretShares and SecretThreshold are used when unseal initializes vault
+	SecretShares    int
+	SecretThreshold int
+	// DryRun reports the vault writes and Kubernetes changes that would be
+	// made instead of making them, while discovery and health checks still run
+	DryRun bool
+	// RaftJoinTimeout bounds how long a joined follower has to become a
+	// healthy raft voter
+	RaftJoinTimeout time.Duration
+	// PodCondition is waited for before a vault Pod is used, within
+	// PodWaitTimeout
+	PodCondition   kubernetesinternal.PodCondition
+	PodWaitTimeout time.Duration
+	// NewOperations creates the vault api used to initialize, unseal, seal and
+	// join each node, defaulting to NewVaultOperations
+	NewOperations VaultOperationsFactory
+	// NewClient creates the vault api clients used for authenticated requests
+	// such as raft verification, defaulting to vaultapi.NewClient
+	NewClient VaultClientFactory
+	// SkipRaftVerification joins raft followers without confirming they
+	// became healthy voters, for when no token is available
+	SkipRaftVerification bool
+
+	// tls caches the certificates loaded for TLS
+	tls *tlsMaterial
+	// restConfig is set when vault Pods are reached through port-forwards,
+	// which are held in portForwards by Pod
+	restConfig   *rest.Config
+	portForwards map[string]*podPortForward
+	forwardMutex sync.Mutex
+	// events is set when Kubernetes Events are recorded
+	events *eventRecorder
+}
+
+// TLSOptions
+type TLSOptions struct {
+	// Enabled switches vault api addresses to https
+	Enabled bool
+	// CACertFile, ClientCertFile and ClientKeyFile hold PEM encoded files and
+	// take precedence over the same material in Secret
+	CACertFile     string
+	ClientCertFile string
+	ClientKeyFile  string
+	// Secret names a Secret in the vault Namespace holding ca.crt and,
+	// optionally, tls.crt and tls.key
+	Secret string
+	// ServerName is verified against vault certificates instead of the Pod IP
+	ServerName string
+	// SkipVerify disables certificate verification
+	SkipVerify bool
+}
+
+// KeyStoreOptions
+type KeyStoreOptions struct {
+	// Type is one of kubernetes, file or age
+	Type string
+	// Namespace, SecretName, RootTokenKey and UnsealKeyPrefix configure the
+	// kubernetes key store - Namespace defaults to the vault Namespace
+	Namespace       string
+	SecretName      string
+	RootTokenKey    string
+	UnsealKeyPrefix string
+	// Path configures the file and age key stores
+	Path string
 }
 
 // VaultUnsealExecutionOptions
@@ -77,3 +184,138 @@ type VaultUnsealExecutionOptions struct {
 	KubeInClusterConfig bool
 	UnsealLeaderOnly    bool
 }
+
+// VaultInitExecutionOptions
+type VaultInitExecutionOptions struct {
+	KubeInClusterConfig bool
+	SecretShares        int
+	SecretThreshold     int
+	// AutoUnseal initializes a vault whose seal unseals it, issuing recovery
+	// keys instead of unseal keys, and joins the raft followers
+	AutoUnseal        bool
+	RecoveryShares    int
+	RecoveryThreshold int
+	// PGPKeys holds one base64 encoded public key or keybase:<user> entry per
+	// share, encrypting recovery shares with --auto-unseal
+	PGPKeys []string
+	// PGPKeyFiles holds one public key file per share, each file naming its custodian
+	PGPKeyFiles []string
+	// PGPKeysConfigMap names a ConfigMap holding one public key per custodian
+	PGPKeysConfigMap string
+	// RootTokenPGPKey holds a base64 encoded public key or keybase:<user> entry
+	RootTokenPGPKey string
+	// RootTokenPGPKeyFile holds the path to the root token public key
+	RootTokenPGPKeyFile string
+	// Output selects whether initialization data is persisted to the key
+	// store or printed to stdout
+	Output string
+}
+
+// VaultRekeyExecutionOptions
+type VaultRekeyExecutionOptions struct {
+	KubeInClusterConfig bool
+	SecretShares        int
+	SecretThreshold     int
+	// Verify keeps the previous shares active until a threshold of the new
+	// shares has been submitted back to vault
+	Verify bool
+}
+
+// VaultGenerateRootExecutionOptions
+type VaultGenerateRootExecutionOptions struct {
+	KubeInClusterConfig bool
+	// Output selects whether the root token is written to the key store or
+	// printed to stdout
+	Output string
+	// TTLRevoke revokes the root token once it elapses when set
+	TTLRevoke time.Duration
+}
+
+// VaultHardenExecutionOptions
+type VaultHardenExecutionOptions struct {
+	KubeInClusterConfig bool
+	// AuthMethod is the type of the admin auth method, userpass or kubernetes,
+	// and AuthPath the path it is enabled at
+	AuthMethod string
+	AuthPath   string
+	// PolicyName names the admin policy, and PolicyFile optionally holds the
+	// path to HCL replacing the default policy granting every capability
+	PolicyName string
+	PolicyFile string
+	// AdminName names the userpass user or kubernetes auth role
+	AdminName string
+	// AdminSecretName names the Secret in the vault Namespace that a generated
+	// userpass password is written to
+	AdminSecretName string
+	// ServiceAccount and ServiceAccountNamespace locate the service account
+	// bound to the kubernetes auth role
+	ServiceAccount          string
+	ServiceAccountNamespace string
+	// KubernetesHost is the API server address written to the kubernetes auth
+	// config when the method has no config yet
+	KubernetesHost string
+	// TokenTTL is the ttl of tokens issued to the admin identity
+	TokenTTL time.Duration
+}
+
+// VaultKubernetesAuthExecutionOptions
+type VaultKubernetesAuthExecutionOptions struct {
+	KubeInClusterConfig bool
+	// Mount is the path the kubernetes auth method is enabled at
+	Mount string
+	// KubernetesHost and KubernetesCAFile override the API server address and
+	// CA of the handler's in cluster configuration - the host is required out
+	// of cluster
+	KubernetesHost   string
+	KubernetesCAFile string
+	// TokenReviewerJWTFile holds the reviewer token written to vault, which
+	// otherwise reviews tokens with its own service account token
+	TokenReviewerJWTFile string
+	// HandlerTokenReviewerJWT writes the service account token of the
+	// handler's own Pod as the reviewer token - it is bound to the Pod and
+	// expires with it, so vault stops reviewing tokens once it does
+	HandlerTokenReviewerJWT bool
+	// RolesFile holds the path to a yaml or json list of KubernetesAuthRole
+	RolesFile string
+}
+
+// VaultSealExecutionOptions
+type VaultSealExecutionOptions struct {
+	KubeInClusterConfig bool
+	// Timeout bounds how long every node is retried until it reports sealed
+	Timeout time.Duration
+}
+
+// VaultWatchExecutionOptions
+type VaultWatchExecutionOptions struct {
+	KubeInClusterConfig bool
+	// Interval is the rate at which every vault pod's health is checked and
+	// the initial delay before retrying a failed unseal
+	Interval time.Duration
+	// MaxBackoff caps the delay between failed unseal attempts against a node
+	MaxBackoff time.Duration
+}
+
+// VaultTransitExecutionOptions
+type VaultTransitExecutionOptions struct {
+	KubeInClusterConfig bool
+	// Mount and KeyName locate the transit key on the core instance
+	Mount   string
+	KeyName string
+	// PolicyName names the policy scoped to the transit key
+	PolicyName string
+	// TokenPeriod is the renewal period of the transit unseal token
+	TokenPeriod time.Duration
+	// SecretName, SecretNamespace and SecretKey locate the Secret the main
+	// vault cluster reads the transit unseal token from
+	SecretName      string
+	SecretNamespace string
+	SecretKey       string
+	// CoreStatefulSetName names the StatefulSet running the core instance,
+	// which is port-forwarded to when running outside the cluster
+	CoreStatefulSetName string
+	// CoreKeyStoreSecretName and CoreKeyStorePath locate the core instance's
+	// initialization data in place of the main cluster's key store Secret or path
+	CoreKeyStoreSecretName string
+	CoreKeyStorePath       string
+}
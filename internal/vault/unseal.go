@@ -0,0 +1,143 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/kubefirst/vault-handler/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryPolicy controls how unseal shares are submitted to a node
+type RetryPolicy struct {
+	// Timeout bounds each unseal request
+	Timeout time.Duration
+	// MaxAttempts bounds how many times a single share is submitted
+	MaxAttempts int
+	// InitialBackoff is doubled after every failed attempt up to MaxBackoff,
+	// and the delay is jittered between half and all of the result
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// UnsealNode submits unseal shares to a node until it reports unsealed
+//
+// Transient failures are retried according to the policy while errors vault
+// returns for a share, such as an invalid key, fail immediately
+func UnsealNode(vaultClient VaultOperations, node string, unsealKeys []string, policy *RetryPolicy) error {
+	return unsealNodeWithProgress(vaultClient, node, unsealKeys, policy, nil)
+}
+
+// unsealNodeWithProgress unseals a node as UnsealNode does, passing the seal
+// status after every accepted share to onShare when it is set
+func unsealNodeWithProgress(vaultClient VaultOperations, node string, unsealKeys []string, policy *RetryPolicy, onShare func(share int, sealStatus *vaultapi.SealStatusResponse)) (err error) {
+	if policy.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be at least 1, got %v", policy.MaxAttempts)
+	}
+
+	logger := log.WithFields(log.Fields{"node": node, "phase": "unseal"})
+	sealStatus, err := vaultClient.SealStatus()
+	if err != nil {
+		return fmt.Errorf("error retrieving seal status of %s: %s", node, err)
+	}
+	if !sealStatus.Sealed {
+		logger.Infof("%s is already unsealed", node)
+		return nil
+	}
+	// Every attempt against a sealed node is counted and timed
+	recordOutcome := metrics.RecordUnsealAttempt(node)
+	defer func() {
+		recordOutcome(err)
+	}()
+
+	// Start from a clean unseal attempt so that shares submitted by an earlier
+	// interrupted run aren't counted twice
+	if sealStatus.Progress > 0 {
+		logger.Infof("resetting partial unseal progress of %s", node)
+		if _, err := vaultClient.ResetUnsealProcess(); err != nil {
+			return fmt.Errorf("error resetting unseal progress of %s: %s", node, err)
+		}
+	}
+
+	for i, shard := range unsealKeys {
+		logger.Infof("passing unseal shard %v to %s", i+1, node)
+		sealStatus, err := submitUnsealShare(vaultClient, node, i+1, shard, policy)
+		if err != nil {
+			return err
+		}
+		if onShare != nil {
+			onShare(i+1, sealStatus)
+		}
+		if !sealStatus.Sealed {
+			logger.Infof("%s unsealed after %v shards", node, i+1)
+			return nil
+		}
+		logger.Infof("shard accepted, unseal progress of %s is %v/%v", node, sealStatus.Progress, sealStatus.T)
+	}
+
+	return fmt.Errorf("%s is still sealed after all %v available unseal shards were accepted", node, len(unsealKeys))
+}
+
+// nodeHealth returns the health of a node, recording its seal and
+// initialization state as metrics
+func nodeHealth(vaultClient VaultOperations, node string) (*vaultapi.HealthResponse, error) {
+	health, err := vaultClient.Health()
+	if err != nil {
+		return nil, err
+	}
+	metrics.RecordHealth(node, health)
+	return health, nil
+}
+
+// submitUnsealShare passes a single share to a node, retrying transient failures
+func submitUnsealShare(vaultClient VaultOperations, node string, share int, shard string, policy *RetryPolicy) (*vaultapi.SealStatusResponse, error) {
+	var lastErr error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), policy.Timeout)
+		sealStatus, err := vaultClient.UnsealWithContext(ctx, shard)
+		cancel()
+		if err == nil {
+			return sealStatus, nil
+		}
+		if !retryableUnsealError(err) {
+			return nil, fmt.Errorf("%s rejected unseal shard %v: %s", node, share, err)
+		}
+
+		lastErr = err
+		if attempt < policy.MaxAttempts {
+			delay := policy.backoff(attempt)
+			log.WithFields(log.Fields{"node": node, "phase": "unseal"}).Warnf("error passing unseal shard %v to %s, retrying in %s (%d/%d): %s", share, node, delay.Round(time.Millisecond), attempt, policy.MaxAttempts, err)
+			time.Sleep(delay)
+		}
+	}
+
+	return nil, fmt.Errorf("error passing unseal shard %v to %s after %v attempts: %s", share, node, policy.MaxAttempts, lastErr)
+}
+
+// backoff returns the jittered delay before the attempt following the supplied one
+func (policy *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.InitialBackoff << (attempt - 1)
+	if delay > policy.MaxBackoff || delay <= 0 {
+		delay = policy.MaxBackoff
+	}
+	if delay < 2 {
+		return delay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
+// retryableUnsealError returns whether an unseal request failed for a reason
+// that may succeed when retried - vault rejecting the request itself, such as
+// for an invalid key, is not retryable
+func retryableUnsealError(err error) bool {
+	var responseError *vaultapi.ResponseError
+	if errors.As(err, &responseError) {
+		return responseError.StatusCode >= http.StatusInternalServerError || responseError.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
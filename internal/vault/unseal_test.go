@@ -0,0 +1,80 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// unsealFailures fails the first unseal requests of a sealed node with errs,
+// unsealing it once they run out
+type unsealFailures struct {
+	VaultOperations
+	errs     []error
+	attempts int
+}
+
+func (ops *unsealFailures) SealStatus() (*vaultapi.SealStatusResponse, error) {
+	return &vaultapi.SealStatusResponse{Sealed: true, T: 1, N: 1}, nil
+}
+
+func (ops *unsealFailures) UnsealWithContext(ctx context.Context, shard string) (*vaultapi.SealStatusResponse, error) {
+	ops.attempts++
+	if ops.attempts <= len(ops.errs) {
+		return nil, ops.errs[ops.attempts-1]
+	}
+	return &vaultapi.SealStatusResponse{Sealed: false, T: 1, N: 1}, nil
+}
+
+func TestUnsealRetryPolicy(t *testing.T) {
+	policy := &RetryPolicy{Timeout: time.Second, MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	invalidKey := &vaultapi.ResponseError{HTTPMethod: http.MethodPut, StatusCode: http.StatusBadRequest, Errors: []string{"invalid key"}}
+	unavailable := &vaultapi.ResponseError{HTTPMethod: http.MethodPut, StatusCode: http.StatusServiceUnavailable, Errors: []string{"Vault is sealed"}}
+	throttled := &vaultapi.ResponseError{HTTPMethod: http.MethodPut, StatusCode: http.StatusTooManyRequests}
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      string
+	}{
+		{
+			name:         "A rejected share should stop after one attempt",
+			errs:         []error{invalidKey},
+			wantAttempts: 1,
+			wantErr:      "vault-0 rejected unseal shard 1",
+		},
+		{
+			name:         "A transient error should be retried until the share is accepted",
+			errs:         []error{unavailable, throttled},
+			wantAttempts: 3,
+		},
+		{
+			name:         "Transient errors should be retried up to the attempt limit",
+			errs:         []error{unavailable, errors.New("connection refused"), unavailable, unavailable},
+			wantAttempts: 3,
+			wantErr:      "error passing unseal shard 1 to vault-0 after 3 attempts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := &unsealFailures{errs: tt.errs}
+			err := unsealNodeWithProgress(ops, "vault-0", []string{"a1a1a1a1"}, policy, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("unsealNodeWithProgress() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unsealNodeWithProgress() error = %v", err)
+			}
+			if ops.attempts != tt.wantAttempts {
+				t.Errorf("unseal was attempted %v times, want %v", ops.attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
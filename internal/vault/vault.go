@@ -1,33 +1,138 @@
 package vault
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	vaultapi "github.com/hashicorp/vault/api"
-	kubernetesinternal "github.com/kubefirst/vault-handler/internal/kubernetes"
-	"k8s.io/client-go/kubernetes"
+	"github.com/kubefirst/vault-handler/internal/logging"
+	log "github.com/sirupsen/logrus"
 )
 
-// parseExistingVaultInitSecret returns the value of a vault initialization secret if it exists
-func parseExistingVaultInitSecret(clientset *kubernetes.Clientset) (*vaultapi.InitResponse, error) {
-	// If vault has already been initialized, the response is formatted to contain the value
-	// of the initialization secret
-	secret, err := kubernetesinternal.ReadSecretV2(clientset, VaultNamespace, VaultSecretName)
+// newInitData returns the initialization data to persist for an init request
+// and its response
+func newInitData(initRequest *vaultapi.InitRequest, initResponse *vaultapi.InitResponse) *InitData {
+	data := &InitData{
+		Version:           InitDataVersion,
+		InitResponse:      *initResponse,
+		SecretShares:      initRequest.SecretShares,
+		SecretThreshold:   initRequest.SecretThreshold,
+		RecoveryShares:    initRequest.RecoveryShares,
+		RecoveryThreshold: initRequest.RecoveryThreshold,
+		CreatedAt:         time.Now().UTC(),
+	}
+	data.redactSecrets()
+	return data
+}
+
+// redactSecrets keeps the keys and root token of initialization data out of logs
+func (data *InitData) redactSecrets() {
+	logging.Redact(data.Keys...)
+	logging.Redact(data.KeysB64...)
+	logging.Redact(data.RecoveryKeys...)
+	logging.Redact(data.RecoveryKeysB64...)
+	logging.Redact(data.RootToken)
+}
+
+// autoUnsealed returns whether vault was initialized with an auto unseal seal,
+// in which case only recovery keys were issued
+func (data *InitData) autoUnsealed() bool {
+	return len(data.Keys) == 0 && (len(data.RecoveryKeys) > 0 || data.RecoveryShares > 0)
+}
+
+// upgrade brings initialization data loaded from an earlier layout up to
+// InitDataVersion before it is replaced - the original layout didn't record
+// the share threshold, so it is read from the seal status of vault
+func (data *InitData) upgrade(vaultClient VaultOperations) error {
+	data.Version = InitDataVersion
+	if data.SecretThreshold > 0 || data.RecoveryThreshold > 0 {
+		return nil
+	}
+
+	status, err := vaultClient.SealStatus()
 	if err != nil {
-		return &vaultapi.InitResponse{}, err
+		return fmt.Errorf("error retrieving the share threshold missing from the initialization data: %s", err)
 	}
+	if data.autoUnsealed() {
+		data.RecoveryThreshold = status.T
+	} else {
+		data.SecretThreshold = status.T
+	}
+	return nil
+}
 
-	// Add root-unseal-key entries to slice
-	var rkSlice []string
+// describeCluster records the vault cluster name and id when vault reports them
+func (data *InitData) describeCluster(vaultClient VaultOperations) {
+	health, err := vaultClient.Health()
+	if err != nil {
+		log.Warnf("unable to record vault cluster details: %s", err)
+		return
+	}
+	data.ClusterName = health.ClusterName
+	data.ClusterID = health.ClusterID
+}
+
+// parseExistingVaultInitSecret returns the initialization data held in the
+// content of a vault initialization secret
+//
+// Secrets written in the versioned layout hold the full initialization data
+// as json, while the original layout only holds the root token and one entry
+// per unseal key named by share number
+func parseExistingVaultInitSecret(secret map[string]string, rootTokenKey string, unsealKeyPrefix string) (*InitData, error) {
+	if content, ok := secret[VaultInitDataKey]; ok {
+		data := &InitData{}
+		if err := json.Unmarshal([]byte(content), data); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %s", VaultInitDataKey, err)
+		}
+		if data.Version > InitDataVersion {
+			return nil, fmt.Errorf("initialization data version %v is newer than the supported version %v", data.Version, InitDataVersion)
+		}
+		data.redactSecrets()
+		return data, nil
+	}
+
+	// Order root-unseal-key entries by share number
+	shares := make(map[int]string)
+	var shareNumbers []int
 	for key, value := range secret {
-		if strings.Contains(key, "root-unseal-key-") {
-			rkSlice = append(rkSlice, value)
+		if !strings.HasPrefix(key, unsealKeyPrefix) {
+			continue
+		}
+		shareNumber, err := strconv.Atoi(strings.TrimPrefix(key, unsealKeyPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("unexpected unseal key entry %s", key)
 		}
+		shares[shareNumber] = value
+		shareNumbers = append(shareNumbers, shareNumber)
 	}
+	sort.Ints(shareNumbers)
 
-	existingInitResponse := &vaultapi.InitResponse{
-		Keys:      rkSlice,
-		RootToken: secret["root-token"],
+	var rkSlice []string
+	for _, shareNumber := range shareNumbers {
+		rkSlice = append(rkSlice, shares[shareNumber])
+	}
+
+	data := &InitData{
+		Version: 1,
+		InitResponse: vaultapi.InitResponse{
+			Keys:      rkSlice,
+			RootToken: secret[rootTokenKey],
+		},
+		SecretShares: len(rkSlice),
+	}
+
+	// Shares encrypted to custodians carry their custodian metadata
+	if secret[VaultPGPEncryptedKey] == "true" {
+		data.PGPEncrypted = true
+		data.RootTokenCustodian = secret[VaultRootTokenCustodianKey]
+		if err := json.Unmarshal([]byte(secret[VaultCustodiansKey]), &data.Custodians); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %s", VaultCustodiansKey, err)
+		}
 	}
-	return existingInitResponse, nil
+	data.redactSecrets()
+	return data, nil
 }
@@ -0,0 +1,124 @@
+package vault
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+func TestParseExistingVaultInitSecret(t *testing.T) {
+	versioned := &InitData{
+		Version: InitDataVersion,
+		InitResponse: vaultapi.InitResponse{
+			Keys:      []string{"a1", "b2", "c3"},
+			KeysB64:   []string{"YTE=", "YjI=", "YzM="},
+			RootToken: "hvs.root",
+		},
+		SecretShares:    3,
+		SecretThreshold: 2,
+		ClusterName:     "vault-cluster-1234",
+		ClusterID:       "1234",
+		CreatedAt:       time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	versionedJSON, _ := json.Marshal(versioned)
+
+	type args struct {
+		secret map[string]string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *InitData
+		wantErr bool
+	}{
+		{
+			name: "The original layout should return unseal keys in share order",
+			args: args{
+				secret: map[string]string{
+					"root-token":         "hvs.root",
+					"root-unseal-key-10": "j",
+					"root-unseal-key-2":  "b",
+					"root-unseal-key-1":  "a",
+					"root-unseal-key-3":  "c",
+				},
+			},
+			want: &InitData{
+				Version: 1,
+				InitResponse: vaultapi.InitResponse{
+					Keys:      []string{"a", "b", "c", "j"},
+					RootToken: "hvs.root",
+				},
+				SecretShares: 4,
+			},
+		},
+		{
+			name: "The original layout with pgp encrypted shares should return custodians",
+			args: args{
+				secret: map[string]string{
+					"root-token":           "encrypted-root",
+					"root-unseal-key-1":    "encrypted-a",
+					"pgp-encrypted":        "true",
+					"custodians":           `["alice"]`,
+					"root-token-custodian": "bob",
+				},
+			},
+			want: &InitData{
+				Version: 1,
+				InitResponse: vaultapi.InitResponse{
+					Keys:      []string{"encrypted-a"},
+					RootToken: "encrypted-root",
+				},
+				SecretShares:       1,
+				PGPEncrypted:       true,
+				Custodians:         []string{"alice"},
+				RootTokenCustodian: "bob",
+			},
+		},
+		{
+			name: "The versioned layout should return the stored initialization data",
+			args: args{
+				secret: map[string]string{
+					"init.json":         string(versionedJSON),
+					"init-data-version": "2",
+					"root-token":        "hvs.root",
+					"root-unseal-key-1": "a1",
+					"root-unseal-key-2": "b2",
+					"root-unseal-key-3": "c3",
+				},
+			},
+			want: versioned,
+		},
+		{
+			name: "A newer layout version should return an error",
+			args: args{
+				secret: map[string]string{
+					"init.json": `{"version": 99}`,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "An unseal key entry without a share number should return an error",
+			args: args{
+				secret: map[string]string{
+					"root-unseal-key-one": "a",
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExistingVaultInitSecret(tt.args.secret, VaultRootTokenKey, VaultUnsealKeyPrefix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExistingVaultInitSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseExistingVaultInitSecret() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kubefirst/vault-handler/internal/metrics"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// nodeBackoff tracks failed unseal attempts for a single node
+type nodeBackoff struct {
+	failures    int
+	nextAttempt time.Time
+}
+
+// raftNodeWatcher holds the state shared between informer events and health checks
+type raftNodeWatcher struct {
+	conf      *VaultConfiguration
+	clientset kubernetes.Interface
+	keyStore  KeyStore
+	opts      *VaultWatchExecutionOptions
+	// mu guards backoff, which informer event handlers also reset
+	mu      sync.Mutex
+	backoff map[string]*nodeBackoff
+	// unsealKeys is loaded the first time a sealed node is found
+	unsealKeys []string
+	// trigger requests an immediate health check when a Pod changes
+	trigger chan struct{}
+}
+
+// WatchRaftNodes watches the vault StatefulSet Pods and unseals any node that
+// comes back sealed until the context is cancelled
+func (conf *VaultConfiguration) WatchRaftNodes(ctx context.Context, clientset kubernetes.Interface, opts *VaultWatchExecutionOptions) error {
+	if opts.Interval <= 0 || opts.MaxBackoff < opts.Interval {
+		return fmt.Errorf("interval must be positive and no greater than the maximum backoff")
+	}
+
+	cluster, err := conf.DiscoverRaftCluster(clientset)
+	if err != nil {
+		return err
+	}
+
+	keyStore, err := conf.keyStore(clientset)
+	if err != nil {
+		return err
+	}
+
+	watcher := &raftNodeWatcher{
+		conf:      conf,
+		clientset: clientset,
+		keyStore:  keyStore,
+		opts:      opts,
+		backoff:   make(map[string]*nodeBackoff),
+		trigger:   make(chan struct{}, 1),
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		clientset,
+		0,
+		informers.WithNamespace(cluster.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = cluster.Selector
+		}),
+	)
+	podInformer := factory.Core().V1().Pods()
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			log.WithFields(podFields(obj.(*v1.Pod), "watch")).Infof("watching vault pod %s", obj.(*v1.Pod).Name)
+			watcher.requestCheck()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, newPod := oldObj.(*v1.Pod), newObj.(*v1.Pod)
+			if podRestarted(oldPod, newPod) {
+				log.WithFields(podFields(newPod, "watch")).Infof("vault pod %s restarted, checking seal status", newPod.Name)
+				watcher.resetBackoff(newPod.Name)
+				watcher.requestCheck()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				log.WithFields(podFields(pod, "watch")).Infof("vault pod %s was deleted", pod.Name)
+				watcher.resetBackoff(pod.Name)
+			}
+		},
+	})
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), podInformer.Informer().HasSynced) {
+		return fmt.Errorf("timed out waiting for the vault pod cache to sync")
+	}
+	log.Infof("watching %v vault pods in StatefulSet %s every %s", len(cluster.Nodes), cluster.StatefulSetName, opts.Interval)
+
+	selector, err := labels.Parse(cluster.Selector)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		pods, err := podInformer.Lister().Pods(cluster.Namespace).List(selector)
+		if err != nil {
+			return err
+		}
+		reconciled := len(pods) == len(cluster.Nodes)
+		for _, pod := range pods {
+			if !watcher.checkNode(pod) {
+				reconciled = false
+			}
+		}
+		if reconciled {
+			metrics.RecordReconcile()
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("stopping vault pod watch")
+			return nil
+		case <-ticker.C:
+		case <-watcher.trigger:
+		}
+	}
+}
+
+// checkNode unseals a single node if it reports sealed and is not backing off,
+// returning whether the node was left initialized and unsealed
+func (watcher *raftNodeWatcher) checkNode(pod *v1.Pod) bool {
+	node := pod.Name
+	if pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+		return false
+	}
+	if watcher.backingOff(node) {
+		return false
+	}
+
+	vaultClient, err := watcher.conf.newVaultOperationsForPod(watcher.clientset, pod)
+	if err != nil {
+		watcher.recordFailure(node, err)
+		return false
+	}
+	health, err := nodeHealth(vaultClient, node)
+	if err != nil {
+		watcher.recordFailure(node, fmt.Errorf("error retrieving health of %s: %s", node, err))
+		return false
+	}
+
+	switch {
+	case !health.Initialized:
+		log.WithFields(podFields(pod, "watch")).Warnf("%s is not initialized, run unseal to initialize or join it", node)
+		return false
+	case health.Sealed:
+		log.WithFields(podFields(pod, "unseal")).Infof("%s is sealed, unsealing", node)
+		if err := watcher.unseal(vaultClient, pod); err != nil {
+			watcher.recordFailure(node, err)
+			return false
+		}
+		watcher.resetBackoff(node)
+		// A dry run only planned the unseal
+		return !watcher.conf.DryRun
+	default:
+		watcher.resetBackoff(node)
+		return true
+	}
+}
+
+// unseal passes the stored unseal keys to a node, loading them on first use
+func (watcher *raftNodeWatcher) unseal(vaultClient VaultOperations, pod *v1.Pod) error {
+	if watcher.unsealKeys == nil {
+		data, err := watcher.keyStore.Load()
+		if err != nil {
+			return err
+		}
+		unsealKeys, err := watcher.conf.unsealKeys(data)
+		if err != nil {
+			return err
+		}
+		watcher.unsealKeys = unsealKeys
+	}
+	if err := watcher.conf.unsealNode(vaultClient, pod, pod.Name, watcher.unsealKeys, 0); err != nil {
+		// Reload the keys on the next attempt in case vault was rekeyed
+		watcher.unsealKeys = nil
+		return err
+	}
+	watcher.conf.discardKeyStoreBackup(watcher.keyStore, true)
+	return nil
+}
+
+// recordFailure backs off further attempts against a node exponentially
+func (watcher *raftNodeWatcher) recordFailure(node string, err error) {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+
+	backoff, ok := watcher.backoff[node]
+	if !ok {
+		backoff = &nodeBackoff{}
+		watcher.backoff[node] = backoff
+	}
+	delay := watcher.opts.Interval << backoff.failures
+	if delay > watcher.opts.MaxBackoff || delay <= 0 {
+		delay = watcher.opts.MaxBackoff
+	} else {
+		backoff.failures++
+	}
+	backoff.nextAttempt = time.Now().Add(delay)
+	log.WithFields(log.Fields{"node": node, "phase": "unseal"}).Errorf("error unsealing %s, retrying in %s: %s", node, delay, err)
+}
+
+// resetBackoff clears failed attempts for a node
+func (watcher *raftNodeWatcher) resetBackoff(node string) {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+
+	delete(watcher.backoff, node)
+}
+
+// backingOff returns whether attempts against a node are currently delayed
+func (watcher *raftNodeWatcher) backingOff(node string) bool {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+
+	backoff, ok := watcher.backoff[node]
+	return ok && time.Now().Before(backoff.nextAttempt)
+}
+
+// requestCheck schedules an immediate health check without blocking
+func (watcher *raftNodeWatcher) requestCheck() {
+	select {
+	case watcher.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// podRestarted returns whether a Pod was recreated or had a container restart
+func podRestarted(oldPod *v1.Pod, newPod *v1.Pod) bool {
+	if oldPod.UID != newPod.UID || oldPod.Status.PodIP != newPod.Status.PodIP {
+		return true
+	}
+	restarts := func(pod *v1.Pod) int32 {
+		var count int32
+		for _, status := range pod.Status.ContainerStatuses {
+			count += status.RestartCount
+		}
+		return count
+	}
+	return restarts(oldPod) != restarts(newPod)
+}
@@ -0,0 +1,86 @@
+package vault
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestPodRestarted(t *testing.T) {
+	pod := func(uid string, ip string, restarts ...int32) *v1.Pod {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-0", UID: types.UID(uid)},
+			Status:     v1.PodStatus{PodIP: ip},
+		}
+		for _, count := range restarts {
+			pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, v1.ContainerStatus{RestartCount: count})
+		}
+		return pod
+	}
+
+	tests := []struct {
+		name   string
+		oldPod *v1.Pod
+		newPod *v1.Pod
+		want   bool
+	}{
+		{
+			name:   "An unchanged Pod should not count as restarted",
+			oldPod: pod("a", "10.0.0.1", 0, 1),
+			newPod: pod("a", "10.0.0.1", 0, 1),
+		},
+		{
+			name:   "A container restart should count as restarted",
+			oldPod: pod("a", "10.0.0.1", 0, 1),
+			newPod: pod("a", "10.0.0.1", 1, 1),
+			want:   true,
+		},
+		{
+			name:   "A recreated Pod should count as restarted even with the same restart count",
+			oldPod: pod("a", "10.0.0.1", 0),
+			newPod: pod("b", "10.0.0.1", 0),
+			want:   true,
+		},
+		{
+			name:   "A Pod with a new IP should count as restarted",
+			oldPod: pod("a", "10.0.0.1", 0),
+			newPod: pod("a", "10.0.0.9", 0),
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := podRestarted(tt.oldPod, tt.newPod); got != tt.want {
+				t.Errorf("podRestarted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatcherBackoff(t *testing.T) {
+	watcher := &raftNodeWatcher{
+		opts:    &VaultWatchExecutionOptions{Interval: time.Minute, MaxBackoff: 5 * time.Minute},
+		backoff: make(map[string]*nodeBackoff),
+	}
+
+	// Delays double from the interval until they are capped at the maximum
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		watcher.recordFailure("vault-0", errors.New("connection refused"))
+		got := time.Until(watcher.backoff["vault-0"].nextAttempt).Round(time.Minute)
+		if got != want {
+			t.Errorf("backoff after %v failures = %s, want %s", watcher.backoff["vault-0"].failures, got, want)
+		}
+	}
+	if !watcher.backingOff("vault-0") || watcher.backingOff("vault-1") {
+		t.Errorf("backingOff() = %v for the failing node and %v for another, want only the failing node delayed", watcher.backingOff("vault-0"), watcher.backingOff("vault-1"))
+	}
+
+	watcher.resetBackoff("vault-0")
+	if watcher.backingOff("vault-0") {
+		t.Error("backingOff() = true after a reset, want the node checked again")
+	}
+}